@@ -0,0 +1,436 @@
+package qb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Действия, фиксируемые в аудите
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditSoftDelete = "soft_delete"
+	AuditRestore    = "restore"
+)
+
+// DefaultAuditTable таблица аудита по умолчанию
+const DefaultAuditTable = "audits"
+
+// AuditLog представляет запись аудита
+type AuditLog struct {
+	ID        int64     `db:"id" json:"id"`
+	TableName string    `db:"table_name" json:"table_name"`
+	RecordID  any       `db:"record_id" json:"record_id"`
+	Action    string    `db:"action" json:"action"`
+	OldData   []byte    `db:"old_data" json:"old_data"`
+	NewData   []byte    `db:"new_data" json:"new_data"`
+	Diff      []byte    `db:"diff" json:"diff"`
+	UserID    any       `db:"user_id" json:"user_id"`
+	RequestID string    `db:"request_id" json:"request_id"`
+	Metadata  []byte    `db:"metadata" json:"metadata"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// AuditChange изменение значения одной колонки
+type AuditChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// AuditSink получатель записей аудита вместо таблицы
+type AuditSink interface {
+	WriteAudit(ctx context.Context, entries []AuditLog) error
+}
+
+// AuditMeta описывает инициатора изменений и параметры запроса
+type AuditMeta struct {
+	UserID    any            `json:"user_id,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	IP        string         `json:"ip,omitempty"`
+	UserAgent string         `json:"user_agent,omitempty"`
+	Extra     map[string]any `json:"extra,omitempty"`
+}
+
+type auditMetaKey struct{}
+
+// WithAuditMeta сохраняет метаданные аудита в контексте
+func WithAuditMeta(ctx context.Context, meta AuditMeta) context.Context {
+	return context.WithValue(ctx, auditMetaKey{}, meta)
+}
+
+// AuditMetaFromContext возвращает метаданные аудита из контекста
+func AuditMetaFromContext(ctx context.Context) (AuditMeta, bool) {
+	if ctx == nil {
+		return AuditMeta{}, false
+	}
+	meta, ok := ctx.Value(auditMetaKey{}).(AuditMeta)
+	return meta, ok
+}
+
+// SetAuditTable задает таблицу для записей аудита
+func (q *QueryBuilder) SetAuditTable(table string) {
+	q.auditTable = table
+}
+
+// SetAuditSink задает собственный получатель записей аудита
+func (q *QueryBuilder) SetAuditSink(sink AuditSink) {
+	q.auditSink = sink
+}
+
+// SetPrimaryKey задает первичный ключ таблицы, если он не id.
+// По нему аудит находит измененные строки в UpdateMap, Delete и других запросах без структуры
+func (q *QueryBuilder) SetPrimaryKey(table string, column string) {
+	if q.primaryKeys == nil {
+		q.primaryKeys = make(map[string]string)
+	}
+	q.primaryKeys[normalizeTable(table)] = column
+}
+
+// primaryKey возвращает первичный ключ таблицы билдера:
+// колонку pk структуры data, ключ из SetPrimaryKey или id
+func (qb *Builder) primaryKey(data any) string {
+	if t := reflect.TypeOf(data); t != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			if f, ok := mapStruct(t).pk(); ok {
+				return f.Column
+			}
+		}
+	}
+	if column := qb.queryBuilder.primaryKeys[normalizeTable(qb.tableName)]; column != "" {
+		return column
+	}
+	return "id"
+}
+
+func (q *QueryBuilder) getAuditTable() string {
+	if q.auditTable == "" {
+		return DefaultAuditTable
+	}
+	return q.auditTable
+}
+
+// WithAudit включает аудит для запроса.
+// Если userID равен nil, инициатор берется из AuditMeta контекста.
+func (qb *Builder) WithAudit(userID any) *Builder {
+	qb.auditEnabled = true
+	qb.auditUserID = userID
+	return qb
+}
+
+// auditedExec выполняет изменяющий запрос, фиксируя состояние строк до и после.
+// Если keys равен nil, затрагиваемые строки определяются условиями билдера
+// по колонке key, а без нее — по первичному ключу таблицы.
+func (qb *Builder) auditedExec(action string, key string, keys []any, query string, args ...any) error {
+	_, err := qb.auditedExecRows(action, key, keys, query, args...)
+	return err
+}
+
+// auditedExecRows выполняет изменяющий запрос как auditedExec и возвращает число затронутых строк
+func (qb *Builder) auditedExecRows(action string, key string, keys []any, query string, args ...any) (int64, error) {
+	if !qb.auditEnabled {
+		return qb.execRowsContext(qb.ctx, query, args...)
+	}
+	if qb.needsAuditTransaction() {
+		var rows int64
+		err := qb.inTransaction(func(b *Builder) error {
+			var err error
+			rows, err = b.auditedExecRows(action, key, keys, query, args...)
+			return err
+		})
+		return rows, err
+	}
+
+	var before []map[string]any
+	var err error
+	if keys == nil {
+		if key == "" {
+			key = qb.primaryKey(nil)
+		}
+		before, err = qb.auditRowsWhere()
+	} else {
+		before, err = qb.auditRowsByKeys(key, keys)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("audit: %w", err)
+	}
+
+	rows, err := qb.execRowsContext(qb.ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var after []map[string]any
+	if action != AuditDelete && len(before) > 0 {
+		after, err = qb.auditRowsByKeys(key, auditKeys(before, key))
+		if err != nil {
+			return rows, fmt.Errorf("audit: %w", err)
+		}
+	}
+
+	return rows, qb.auditRecord(action, key, before, after)
+}
+
+// needsAuditTransaction проверяет, нужно ли открыть транзакцию,
+// чтобы изменение и его запись аудита сохранялись вместе
+func (qb *Builder) needsAuditTransaction() bool {
+	return qb.auditEnabled && qb.tx == nil
+}
+
+// auditInserted фиксирует вставленные записи с первичным ключом key
+func (qb *Builder) auditInserted(key string, rows ...map[string]any) error {
+	if !qb.auditEnabled || len(rows) == 0 {
+		return nil
+	}
+	return qb.auditRecord(AuditCreate, key, nil, rows)
+}
+
+// auditRowsWhere выбирает строки, подпадающие под условия билдера
+func (qb *Builder) auditRowsWhere() ([]map[string]any, error) {
+	ref, from := qb.tableName, qb.table()
+	if qb.alias != "" {
+		ref = qb.alias
+		from = fmt.Sprintf("%s AS %s", qb.table(), qb.alias)
+	}
+	body, args := qb.buildBodyQuery()
+	return qb.auditQuery(fmt.Sprintf("SELECT %s.* FROM %s", ref, from)+body, args...)
+}
+
+// auditRowsByKeys выбирает строки по списку ключей
+func (qb *Builder) auditRowsByKeys(key string, keys []any) ([]map[string]any, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)",
+		qb.table(), key, strings.TrimSuffix(strings.Repeat("?,", len(keys)), ","))
+	args := keys
+	if clause, tenantArgs, ok := qb.tenantCondition(qb.tableName, ""); ok {
+		query += " AND " + clause
+		args = append(append([]any(nil), keys...), tenantArgs...)
+	}
+	return qb.auditQuery(query, args...)
+}
+
+func (qb *Builder) auditQuery(query string, args ...any) (result []map[string]any, err error) {
+	query = qb.rebindQuery(query)
+	ctx, event, err := qb.beforeQuery(qb.ctx, query, args)
+	start := time.Now()
+	defer func() {
+		qb.afterQuery(ctx, "auditQuery", start, event, int64(len(result)), err)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qb.getExecutor().QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := make(map[string]any)
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		result = append(result, normalizeAuditRow(row))
+	}
+	return result, rows.Err()
+}
+
+// auditRecord сопоставляет строки до и после изменения и сохраняет записи аудита
+func (qb *Builder) auditRecord(action string, key string, before, after []map[string]any) error {
+	meta, _ := AuditMetaFromContext(qb.ctx)
+	userID := qb.auditUser()
+	var metadata []byte
+	if meta.IP != "" || meta.UserAgent != "" || len(meta.Extra) > 0 {
+		var err error
+		metadata, err = json.Marshal(map[string]any{
+			"ip":         meta.IP,
+			"user_agent": meta.UserAgent,
+			"extra":      meta.Extra,
+		})
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+
+	afterByKey := make(map[string]int, len(after))
+	if len(before) > 0 {
+		for i, row := range after {
+			afterByKey[fmt.Sprint(row[key])] = i
+		}
+	}
+	matched := make(map[int]bool, len(after))
+
+	now := time.Now().UTC()
+	entries := make([]AuditLog, 0, len(before)+len(after))
+	add := func(recordID any, oldRow, newRow map[string]any) error {
+		entry := AuditLog{
+			TableName: qb.tableName,
+			RecordID:  recordID,
+			Action:    action,
+			UserID:    userID,
+			RequestID: meta.RequestID,
+			Metadata:  metadata,
+			CreatedAt: now,
+		}
+		var err error
+		if oldRow != nil {
+			if entry.OldData, err = json.Marshal(oldRow); err != nil {
+				return err
+			}
+		}
+		if newRow != nil {
+			if entry.NewData, err = json.Marshal(newRow); err != nil {
+				return err
+			}
+		}
+		if entry.Diff, err = json.Marshal(auditDiff(oldRow, newRow)); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+
+	for _, oldRow := range before {
+		var newRow map[string]any
+		if i, ok := afterByKey[fmt.Sprint(oldRow[key])]; ok {
+			newRow = after[i]
+			matched[i] = true
+		}
+		if err := add(oldRow[key], oldRow, newRow); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+	for i, newRow := range after {
+		if matched[i] {
+			continue
+		}
+		if err := add(newRow[key], nil, newRow); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := qb.writeAudit(entries); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+// writeAudit передает записи получателю или пишет их в таблицу аудита
+// через тот же исполнитель, что и основной запрос
+func (qb *Builder) writeAudit(entries []AuditLog) error {
+	if sink := qb.queryBuilder.auditSink; sink != nil {
+		return sink.WriteAudit(qb.ctx, entries)
+	}
+
+	records := make([]map[string]any, len(entries))
+	for i, e := range entries {
+		var recordID any
+		if e.RecordID != nil {
+			recordID = fmt.Sprint(e.RecordID)
+		}
+		records[i] = map[string]any{
+			"table_name": e.TableName,
+			"record_id":  recordID,
+			"action":     e.Action,
+			"old_data":   e.OldData,
+			"new_data":   e.NewData,
+			"diff":       e.Diff,
+			"user_id":    e.UserID,
+			"request_id": e.RequestID,
+			"metadata":   e.Metadata,
+			"created_at": e.CreatedAt,
+		}
+	}
+
+	audit := &Builder{
+		db:           qb.db,
+		tableName:    qb.queryBuilder.getAuditTable(),
+		queryBuilder: qb.queryBuilder,
+		ctx:          qb.ctx,
+		tx:           qb.tx,
+	}
+	return audit.BatchInsert(records)
+}
+
+// auditDiff возвращает изменившиеся колонки
+func auditDiff(oldRow, newRow map[string]any) map[string]AuditChange {
+	diff := make(map[string]AuditChange)
+	for col, oldVal := range oldRow {
+		newVal, ok := newRow[col]
+		if newRow != nil && ok && reflect.DeepEqual(oldVal, newVal) {
+			continue
+		}
+		diff[col] = AuditChange{Old: oldVal, New: newVal}
+	}
+	for col, newVal := range newRow {
+		if _, ok := oldRow[col]; !ok {
+			diff[col] = AuditChange{New: newVal}
+		}
+	}
+	return diff
+}
+
+// auditKeys собирает значения ключевой колонки
+func auditKeys(rows []map[string]any, key string) []any {
+	keys := make([]any, 0, len(rows))
+	for _, row := range rows {
+		if v, ok := row[key]; ok && v != nil {
+			keys = append(keys, v)
+		}
+	}
+	return keys
+}
+
+// auditRecords копирует вставляемые записи для аудита
+func auditRecords(records []map[string]any) []map[string]any {
+	rows := make([]map[string]any, len(records))
+	for i, record := range records {
+		row := make(map[string]any, len(record))
+		for col, val := range record {
+			row[col] = val
+		}
+		rows[i] = normalizeAuditRow(row)
+	}
+	return rows
+}
+
+// normalizeAuditRow приводит значения драйвера к сериализуемому виду
+func normalizeAuditRow(row map[string]any) map[string]any {
+	for col, val := range row {
+		if b, ok := val.([]byte); ok {
+			row[col] = string(b)
+		}
+	}
+	return row
+}
+
+// auditColumnsRow собирает строку аудита из колонок и значений вставки
+func auditColumnsRow(columns []string, values []any) map[string]any {
+	row := make(map[string]any, len(columns)+1)
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+	return normalizeAuditRow(row)
+}
+
+// auditUser возвращает пользователя из WithAudit или из AuditMeta контекста
+func (qb *Builder) auditUser() any {
+	if qb.auditUserID != nil {
+		return qb.auditUserID
+	}
+	meta, _ := AuditMetaFromContext(qb.ctx)
+	return meta.UserID
+}
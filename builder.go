@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"reflect"
 	"strings"
 	"time"
 )
@@ -16,6 +15,19 @@ type Condition struct {
 	clause   string
 	nested   []Condition
 	args     []any
+	// subQuery подставляется в clause вместо subQueryPlaceholder при сборке запроса,
+	// его аргументы идут перед args
+	subQuery *Builder
+}
+
+// subQueryPlaceholder место подзапроса в условии или колонке
+const subQueryPlaceholder = "{subquery}"
+
+// derivedTable источник выборки из подзапросов: SubQuery или Union
+type derivedTable struct {
+	queries []*Builder
+	// operator соединяет запросы: UNION или UNION ALL
+	operator string
 }
 
 type Builder struct {
@@ -36,20 +48,50 @@ type Builder struct {
 	cacheKey      string
 	cacheDuration time.Duration
 	events        map[EventType][]EventHandler
+	auditEnabled  bool
+	auditUserID   any
+	metrics       *MetricsCollector
+	queryCache    bool
+	tx            *Transaction
+	usePrimary    bool
+	withoutTenant bool
+	schema        string
+
+	withoutScopes       map[string]bool
+	withoutGlobalScopes bool
+	onlyTrashed         bool
+
+	with            []string
+	withConstraints map[string]func(*Builder)
+	extraColumns    []selectExpr
+	derived         *derivedTable
+
+	// err ошибка построения запроса, возвращается при выполнении
+	err error
 }
 
-// buildConditions собирает условия WHERE в строку
-func buildConditions(conditions []Condition) string {
+// buildConditions собирает условия WHERE в строку с аргументами.
+// Подзапросы собираются в контексте билдера qb
+func (qb *Builder) buildConditions(conditions []Condition) (string, []any) {
 	var parts []string
+	var args []any
 
 	for i, cond := range conditions {
 		var part string
 
-		if len(cond.nested) > 0 {
-			nestedSQL := buildConditions(cond.nested)
+		switch {
+		case len(cond.nested) > 0:
+			nestedSQL, nestedArgs := qb.buildConditions(cond.nested)
 			part = "(" + nestedSQL + ")"
-		} else {
+			args = append(args, nestedArgs...)
+		case cond.subQuery != nil:
+			var subArgs []any
+			part, subArgs = qb.buildSubQuery(cond.clause, cond.subQuery)
+			args = append(args, subArgs...)
+			args = append(args, cond.args...)
+		default:
 			part = cond.clause
+			args = append(args, cond.args...)
 		}
 
 		if i == 0 {
@@ -59,76 +101,132 @@ func buildConditions(conditions []Condition) string {
 		}
 	}
 
-	return strings.Join(parts, " ")
+	return strings.Join(parts, " "), args
 }
 
-// execGet выполняет запрос и получает одну запись
-func (qb *Builder) execGet(dest any, query string, args ...any) (bool, error) {
-	start := time.Now()
+// buildSubQuery подставляет подзапрос в выражение expr
+func (qb *Builder) buildSubQuery(expr string, sub *Builder) (string, []any) {
+	sql, args := sub.nestedIn(qb).buildSelectQuery()
+	return strings.Replace(expr, subQueryPlaceholder, sql, 1), args
+}
 
-	query = qb.rebindQuery(query)
-	err := qb.getExecutor().Get(dest, query, args...)
-	qb.queryBuilder.Debug("execGet", start, query, args)
-	if err != nil {
-		qb.queryBuilder.Error(err.Error(), start, query, args)
+// buildDerived собирает подзапросы источника выборки
+func (qb *Builder) buildDerived() (string, []any) {
+	var parts []string
+	var args []any
+	for _, query := range qb.derived.queries {
+		sql, queryArgs := query.nestedIn(qb).buildSelectQuery()
+		parts = append(parts, sql)
+		args = append(args, queryArgs...)
 	}
-	if errors.Is(err, sql.ErrNoRows) {
-		return false, nil
+	return "(" + strings.Join(parts, " "+qb.derived.operator+" ") + ")", args
+}
+
+// nestedIn возвращает копию подзапроса, выполняемого в составе запроса parent:
+// арендатор, схема и транзакция берутся из внешнего запроса
+func (qb *Builder) nestedIn(parent *Builder) *Builder {
+	sub := *qb
+	if sub.queryBuilder == nil {
+		sub.queryBuilder = parent.queryBuilder
 	}
-	return err == nil, err
+	if parent.ctx != nil {
+		sub.ctx = parent.ctx
+	}
+	if sub.schema == "" {
+		sub.schema = parent.schema
+	}
+	sub.tx = parent.tx
+	sub.withoutTenant = qb.withoutTenant || parent.withoutTenant
+	return &sub
 }
 
-// execSelect выполняет запрос и получает множество записей
-func (qb *Builder) execSelect(dest any, query string, args ...any) (bool, error) {
-	start := time.Now()
-	query = qb.rebindQuery(query)
-	err := qb.getExecutor().Select(dest, query, args...)
-	qb.queryBuilder.Debug("execSelect", start, query, args)
-	if err != nil {
-		qb.queryBuilder.Error(err.Error(), start, query, args)
+// subQueries возвращает подзапросы условий, колонок и источника выборки
+func (qb *Builder) subQueries() []*Builder {
+	var subs []*Builder
+	var walk func(conditions []Condition)
+	walk = func(conditions []Condition) {
+		for _, cond := range conditions {
+			if cond.subQuery != nil {
+				subs = append(subs, cond.subQuery)
+			}
+			walk(cond.nested)
+		}
 	}
-	if errors.Is(err, sql.ErrNoRows) {
-		return false, nil
+	walk(qb.conditions)
+	for _, extra := range qb.extraColumns {
+		if extra.subQuery != nil {
+			subs = append(subs, extra.subQuery)
+		}
 	}
-	return err == nil, err
+	if qb.derived != nil {
+		subs = append(subs, qb.derived.queries...)
+	}
+	return subs
+}
+
+// execGet выполняет запрос и получает одну запись
+func (qb *Builder) execGet(dest any, query string, args ...any) (bool, error) {
+	return qb.execGetContext(qb.ctx, dest, query, args...)
+}
+
+// execSelect выполняет запрос и получает множество записей
+func (qb *Builder) execSelect(dest any, query string, args ...any) (bool, error) {
+	return qb.execSelectContext(qb.ctx, dest, query, args...)
 }
 
 // execExec выполняет запрос без возврата данных
 func (qb *Builder) execExec(query string, args ...any) error {
-	start := time.Now()
-	query = qb.rebindQuery(query)
-	_, err := qb.getExecutor().Exec(query, args...)
-	qb.queryBuilder.Debug("execExec", start, query, args)
-	if err != nil {
-		qb.queryBuilder.Error(err.Error(), start, query, args)
-	}
-	return err
+	return qb.execExecContext(qb.ctx, query, args...)
 }
 
 // execGetContext выполняет запрос с контекстом и получает одну запись
 func (qb *Builder) execGetContext(ctx context.Context, dest any, query string, args ...any) (bool, error) {
-	start := time.Now()
 	query = qb.rebindQuery(query)
-	err := qb.getExecutor().GetContext(ctx, dest, query, args...)
-	qb.queryBuilder.Debug("execGetContext", start, query, args)
-	if err != nil {
-		qb.queryBuilder.Error(err.Error(), start, query, args)
+	if key, ok := qb.cachedKey(query, args); ok {
+		return qb.fetchCached(ctx, key, dest, func(ctx context.Context, dest any) (bool, error) {
+			return qb.getContext(ctx, dest, query, args)
+		})
+	}
+	return qb.getContext(ctx, dest, query, args)
+}
+
+// execSelectContext выполняет запрос с контекстом и получает множество записей
+func (qb *Builder) execSelectContext(ctx context.Context, dest any, query string, args ...any) (bool, error) {
+	query = qb.rebindQuery(query)
+	if key, ok := qb.cachedKey(query, args); ok {
+		return qb.fetchCached(ctx, key, dest, func(ctx context.Context, dest any) (bool, error) {
+			return qb.selectContext(ctx, dest, query, args)
+		})
 	}
+	return qb.selectContext(ctx, dest, query, args)
+}
+
+// getContext выполняет подготовленный запрос и получает одну запись
+func (qb *Builder) getContext(ctx context.Context, dest any, query string, args []any) (bool, error) {
+	ctx, event, err := qb.beforeQuery(ctx, query, args)
+	start := time.Now()
+	if err == nil {
+		err = getInto(ctx, qb.readExecutor(ctx, query), dest, query, args...)
+	}
+	var rows int64
+	if err == nil {
+		rows = 1
+	}
+	qb.afterQuery(ctx, "execGetContext", start, event, rows, err)
 	if errors.Is(err, sql.ErrNoRows) {
 		return false, nil
 	}
 	return err == nil, err
 }
 
-// execSelectContext выполняет запрос с контекстом и получает множество записей
-func (qb *Builder) execSelectContext(ctx context.Context, dest any, query string, args ...any) (bool, error) {
+// selectContext выполняет подготовленный запрос и получает множество записей
+func (qb *Builder) selectContext(ctx context.Context, dest any, query string, args []any) (bool, error) {
+	ctx, event, err := qb.beforeQuery(ctx, query, args)
 	start := time.Now()
-	query = qb.rebindQuery(query)
-	err := qb.getExecutor().SelectContext(ctx, dest, query, args...)
-	qb.queryBuilder.Debug("execSelectContext", start, query, args)
-	if err != nil {
-		qb.queryBuilder.Error(err.Error(), start, query, args)
+	if err == nil {
+		err = selectInto(ctx, qb.readExecutor(ctx, query), dest, query, args...)
 	}
+	qb.afterQuery(ctx, "execSelectContext", start, event, resultLen(dest), err)
 	if errors.Is(err, sql.ErrNoRows) {
 		return false, nil
 	}
@@ -137,14 +235,67 @@ func (qb *Builder) execSelectContext(ctx context.Context, dest any, query string
 
 // execExecContext выполняет запрос с контекстом
 func (qb *Builder) execExecContext(ctx context.Context, query string, args ...any) error {
-	start := time.Now()
-	query = qb.rebindQuery(query)
-	_, err := qb.getExecutor().ExecContext(ctx, query, args...)
-	qb.queryBuilder.Debug("execExecContext", start, query, args)
+	_, err := qb.execResultContext(ctx, query, args...)
+	return err
+}
+
+// execRowsContext выполняет запрос с контекстом и возвращает число затронутых строк
+func (qb *Builder) execRowsContext(ctx context.Context, query string, args ...any) (int64, error) {
+	result, err := qb.execResultContext(ctx, query, args...)
 	if err != nil {
-		qb.queryBuilder.Error(err.Error(), start, query, args)
+		return 0, err
 	}
-	return err
+	return result.RowsAffected()
+}
+
+// execResultContext выполняет запрос с контекстом и возвращает результат драйвера
+func (qb *Builder) execResultContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = qb.rebindQuery(query)
+	ctx, event, err := qb.beforeQuery(ctx, query, args)
+	start := time.Now()
+	var result sql.Result
+	var rows int64
+	if err == nil {
+		result, err = qb.getExecutor().ExecContext(ctx, query, args...)
+	}
+	if err == nil {
+		rows, _ = result.RowsAffected()
+	}
+	qb.afterQuery(ctx, "execExecContext", start, event, rows, err)
+	return result, err
+}
+
+// beforeQuery готовит событие запроса и вызывает хуки перед выполнением
+func (qb *Builder) beforeQuery(ctx context.Context, query string, args []any) (context.Context, QueryEvent, error) {
+	event := QueryEvent{
+		Operation: queryOperation(query),
+		Table:     qb.tableName,
+		SQL:       query,
+		Args:      args,
+	}
+	if qb.err != nil {
+		return ctx, event, qb.err
+	}
+	if err := qb.checkTenant(); err != nil {
+		return ctx, event, err
+	}
+	if err := qb.checkSchema(); err != nil {
+		return ctx, event, err
+	}
+	ctx, err := qb.queryBuilder.beforeQuery(ctx, event)
+	return ctx, event, err
+}
+
+// afterQuery логирует выполненный запрос, передает его в метрики и хуки
+func (qb *Builder) afterQuery(ctx context.Context, msg string, start time.Time, event QueryEvent, rows int64, err error) {
+	event.Duration = time.Since(start)
+	event.Rows = rows
+	event.Err = err
+	if qb.metrics != nil && qb.metrics != qb.queryBuilder.metrics {
+		qb.metrics.Record(metricsEvent(event))
+	}
+	qb.queryBuilder.afterQuery(ctx, msg, start, event)
+	qb.queryBuilder.invalidateAfterWrite(qb.tx, event)
 }
 
 // On регистрирует обработчик события
@@ -175,24 +326,6 @@ func (qb *Builder) rebindQuery(query string) string {
 	return qb.getExecutor().Rebind(query)
 }
 
-func (qb *Builder) getStructInfo(data any) (fields []string, placeholders []string, values map[string]any) {
-	values = make(map[string]any)
-	v := reflect.ValueOf(data)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	t := v.Type()
-
-	for i := 0; i < t.NumField(); i++ {
-		if tag := t.Field(i).Tag.Get("db"); tag != "" && tag != "-" && tag != "id" {
-			fields = append(fields, tag)
-			placeholders = append(placeholders, ":"+tag)
-			values[tag] = v.Field(i).Interface()
-		}
-	}
-	return
-}
-
 // getDriverName возвращает имя драйвера базы данных
 func (qb *Builder) getDriverName() string {
 	return qb.queryBuilder.driverName
@@ -202,21 +335,49 @@ func (qb *Builder) buildBodyQuery() (string, []any) {
 	var args []any
 	var sql strings.Builder
 
+	// Условия арендатора и глобальных скоупов идут в WHERE перед условиями билдера
+	var scopes []string
+	var scopeArgs []any
+
 	for _, join := range qb.joins {
+		table, ref := splitTableAlias(join.tableName)
+		clause, tenantArgs, scoped := qb.tenantCondition(table, ref)
 		if join.Type == CrossJoin {
-			sql.WriteString(fmt.Sprintf(" %s %s", join.Type, join.tableName))
+			sql.WriteString(fmt.Sprintf(" %s %s", join.Type, qb.qualify(join.tableName)))
+			if scoped {
+				scopes = append(scopes, clause)
+				scopeArgs = append(scopeArgs, tenantArgs...)
+			}
 		} else {
-			sql.WriteString(fmt.Sprintf(" %s %s ON %s", join.Type, join.tableName, join.Condition))
+			condition := join.Condition
+			if scoped {
+				condition = "(" + condition + ") AND " + clause
+				args = append(args, tenantArgs...)
+			}
+			sql.WriteString(fmt.Sprintf(" %s %s ON %s", join.Type, qb.qualify(join.tableName), condition))
 		}
 	}
 
-	if len(qb.conditions) > 0 {
-		whereSQL := buildConditions(qb.conditions)
+	if clause, tenantArgs, ok := qb.tenantCondition(qb.tableName, qb.tableRef()); ok {
+		scopes = append([]string{clause}, scopes...)
+		scopeArgs = append(tenantArgs, scopeArgs...)
+	}
+	globalClauses, globalArgs := qb.globalScopeConditions()
+	scopes = append(scopes, globalClauses...)
+	scopeArgs = append(scopeArgs, globalArgs...)
+
+	if len(qb.conditions) > 0 || len(scopes) > 0 {
+		whereSQL, whereArgs := qb.buildConditions(qb.conditions)
+		if len(scopes) > 0 {
+			if whereSQL != "" {
+				scopes = append(scopes, "("+whereSQL+")")
+			}
+			whereSQL = strings.Join(scopes, " AND ")
+		}
 		sql.WriteString(" WHERE " + whereSQL)
 
-		for _, cond := range qb.conditions {
-			args = append(args, cond.args...)
-		}
+		args = append(args, scopeArgs...)
+		args = append(args, whereArgs...)
 	}
 
 	if len(qb.groupBy) > 0 {
@@ -244,74 +405,90 @@ func (qb *Builder) buildBodyQuery() (string, []any) {
 
 // buildQuery собирает полный SQL запрос
 func (qb *Builder) buildSelectQuery() (string, []any) {
-	selectClause := "*"
-	if len(qb.columns) > 0 {
-		selectClause = strings.Join(qb.columns, ", ")
+	columns := qb.columns
+	if len(columns) == 0 {
+		columns = []string{"*"}
+	}
+	var args []any
+	for _, extra := range qb.extraColumns {
+		column, extraArgs := extra.sql, extra.args
+		if extra.subQuery != nil {
+			column, extraArgs = qb.buildSubQuery(extra.sql, extra.subQuery)
+		}
+		columns = append(columns, column)
+		args = append(args, extraArgs...)
+	}
+	selectClause := strings.Join(columns, ", ")
+	tableName := qb.table()
+	if qb.derived != nil {
+		var fromArgs []any
+		tableName, fromArgs = qb.buildDerived()
+		args = append(args, fromArgs...)
 	}
-	tableName := qb.tableName
 	if qb.alias != "" {
 		tableName = fmt.Sprintf("%s AS %s", tableName, qb.alias)
 	}
 
 	head := fmt.Sprintf("SELECT %s FROM %s", selectClause, tableName)
-	body, args := qb.buildBodyQuery()
-	return head + body, args
+	body, bodyArgs := qb.buildBodyQuery()
+	return head + body, append(args, bodyArgs...)
 }
 
 // buildUpdateQuery собирает SQL запрос для UPDATE
-func (qb *Builder) buildUpdateQuery(data any, fields []string) (string, []any) {
+func (qb *Builder) buildUpdateQuery(data any, fields []string, lock *optimisticLock) (string, []any, error) {
 
+	columns, values, err := writeColumns(data, false, fields)
+	if err != nil {
+		return "", nil, err
+	}
+	columns, values = qb.withoutTenantColumn(columns, values)
 	var sets []string
 	var args []any
-
-	if len(fields) > 0 {
-		for _, field := range fields {
-			sets = append(sets, fmt.Sprintf("%s = ?", field))
-			val := reflect.ValueOf(data)
-			if val.Kind() == reflect.Ptr {
-				val = val.Elem()
-			}
-			field_val := val.FieldByName(field)
-			if field_val.IsValid() {
-				args = append(args, field_val.Interface())
-			}
-		}
-	} else {
-		fields, _, values := qb.getStructInfo(data)
-		for _, field := range fields {
-			sets = append(sets, fmt.Sprintf("%s = ?", field))
-			args = append(args, values[field])
+	for i, column := range columns {
+		if lock != nil && column == lock.column {
+			continue
 		}
+		sets = append(sets, column+" = ?")
+		args = append(args, values[i])
+	}
+	if lock != nil {
+		sets, args = lock.apply(sets, args)
 	}
 	tableName := qb.tableName
 	if qb.alias != "" {
 		tableName = fmt.Sprintf("%s AS %s", tableName, qb.alias)
 	}
 
-	head := fmt.Sprintf("UPDATE %s SET %s", qb.tableName, strings.Join(sets, ", "))
+	head := fmt.Sprintf("UPDATE %s SET %s", qb.table(), strings.Join(sets, ", "))
 
 	body, bodyArgs := qb.buildBodyQuery()
 	args = append(args, bodyArgs...)
-	return head + body, args
+	return head + body, args, nil
 
 }
 
 // buildInsertQuery собирает SQL запрос для INSERT
-func (qb *Builder) buildUpdateMapQuery(data map[string]any) (string, []any) {
+func (qb *Builder) buildUpdateMapQuery(data map[string]any, lock *optimisticLock) (string, []any) {
 
 	var sets []string
 	var args []any
 
 	for col, val := range data {
+		if qb.isTenantColumn(col) {
+			continue
+		}
 		sets = append(sets, col+" = ?")
 		args = append(args, val)
 	}
+	if lock != nil {
+		sets, args = lock.apply(sets, args)
+	}
 
 	tableName := qb.tableName
 	if qb.alias != "" {
 		tableName = fmt.Sprintf("%s AS %s", tableName, qb.alias)
 	}
-	head := fmt.Sprintf("UPDATE %s SET %s", qb.tableName, strings.Join(sets, ", "))
+	head := fmt.Sprintf("UPDATE %s SET %s", qb.table(), strings.Join(sets, ", "))
 
 	body, bodyArgs := qb.buildBodyQuery()
 	args = append(args, bodyArgs...)
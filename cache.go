@@ -0,0 +1,460 @@
+package qb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	queryCachePrefix = "qb:query:"
+	cacheTagPrefix   = "qb:tag:"
+	// cacheTagTTL время жизни поколения таблицы в кеше
+	cacheTagTTL = 24 * time.Hour
+)
+
+var queryTablesRe = regexp.MustCompile(`(?i)\b(?:from|join)\s+([\w."` + "`" + `]+)`)
+
+// Cache включает кеширование результатов чтения билдера.
+// Ключ строится по SQL и аргументам, запись помечается всеми таблицами запроса
+// и становится недействительной после любой успешной записи в эти таблицы.
+// Внутри транзакции кеш не используется.
+func (qb *Builder) Cache(ttl time.Duration) *Builder {
+	qb.queryCache = ttl > 0
+	qb.cacheDuration = ttl
+	return qb
+}
+
+// cachedKey возвращает ключ кеша для запроса на чтение
+func (qb *Builder) cachedKey(query string, args []any) (string, bool) {
+	if !qb.queryCache || qb.tx != nil || qb.queryBuilder.cache == nil || queryOperation(query) != "select" {
+		return "", false
+	}
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		encodedArgs = []byte(fmt.Sprintf("%#v", args))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(query))
+	h.Write([]byte{0})
+	h.Write(encodedArgs)
+	for _, table := range queryTables(query) {
+		h.Write([]byte{0})
+		h.Write([]byte(table + "=" + qb.queryBuilder.tableGeneration(table)))
+	}
+	return queryCachePrefix + hex.EncodeToString(h.Sum(nil)), true
+}
+
+// CacheOptions настройки защиты кеша запросов от одновременных промахов
+type CacheOptions struct {
+	// EarlyRefresh коэффициент вероятностного раннего обновления, 0 — выключено.
+	// Чем больше значение, тем раньше до истечения срока запрос обновляется в фоне, обычно 1
+	EarlyRefresh float64
+	// StaleWhileRevalidate сколько после истечения срока отдавать устаревшее значение,
+	// пока одна горутина обновляет его в фоне
+	StaleWhileRevalidate time.Duration
+	// LockTTL время жизни блокировки обновления в кешах с CacheLocker, по умолчанию 5 секунд
+	LockTTL time.Duration
+	// LoadTimeout ограничивает общий запрос при промахе, по умолчанию 30 секунд.
+	// Запрос не отменяется вместе с контекстом первого вызова: его результат ждут и другие
+	LoadTimeout time.Duration
+}
+
+// SetCacheOptions задает настройки обновления кеша запросов
+func (q *QueryBuilder) SetCacheOptions(opts CacheOptions) {
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = defaultCacheLockTTL
+	}
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = defaultCacheLoadTimeout
+	}
+	q.cacheOpts = opts
+}
+
+const (
+	defaultCacheLockTTL     = 5 * time.Second
+	defaultCacheLoadTimeout = 30 * time.Second
+)
+
+func (q *QueryBuilder) cacheLockTTL() time.Duration {
+	if q.cacheOpts.LockTTL <= 0 {
+		return defaultCacheLockTTL
+	}
+	return q.cacheOpts.LockTTL
+}
+
+func (q *QueryBuilder) cacheLoadTimeout() time.Duration {
+	if q.cacheOpts.LoadTimeout <= 0 {
+		return defaultCacheLoadTimeout
+	}
+	return q.cacheOpts.LoadTimeout
+}
+
+// cacheEntry значение в кеше с мягким сроком истечения
+type cacheEntry struct {
+	Value json.RawMessage `json:"v"`
+	// Expires мягкий срок истечения в наносекундах Unix
+	Expires int64 `json:"e"`
+	// Delta время вычисления значения в наносекундах
+	Delta int64 `json:"d"`
+}
+
+// fetchCached возвращает значение из кеша, а при промахе выполняет load один раз
+// для всех одновременных запросов с тем же ключом
+func (qb *Builder) fetchCached(ctx context.Context, key string, dest any, load func(ctx context.Context, dest any) (bool, error)) (bool, error) {
+	q := qb.queryBuilder
+	if reflect.TypeOf(dest).Kind() != reflect.Ptr {
+		return load(ctx, dest)
+	}
+
+	if entry, ok := q.readEntry(ctx, key); ok {
+		now := time.Now().UnixNano()
+		fresh := entry.Expires == 0 || now < entry.Expires
+		if fresh && q.refreshEarly(entry, now) ||
+			!fresh && now < entry.Expires+int64(q.cacheOpts.StaleWhileRevalidate) {
+			qb.refreshCached(ctx, key, dest, load)
+			fresh = true
+		}
+		if fresh && json.Unmarshal(entry.Value, dest) == nil {
+			qb.recordCache(true)
+			return true, nil
+		}
+	}
+	qb.recordCache(false)
+
+	data, found, err := q.flights.do(ctx, key, func() ([]byte, bool, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), q.cacheLoadTimeout())
+		defer cancel()
+		return qb.loadCached(loadCtx, key, dest, load, true)
+	})
+	if err != nil || !found {
+		return found, err
+	}
+	return true, json.Unmarshal(data, dest)
+}
+
+// refreshCached обновляет значение в фоне, если его еще никто не обновляет
+func (qb *Builder) refreshCached(ctx context.Context, key string, dest any, load func(ctx context.Context, dest any) (bool, error)) {
+	ctx = context.WithoutCancel(ctx)
+	qb.queryBuilder.flights.start(key, func() ([]byte, bool, error) {
+		data, found, err := qb.loadCached(ctx, key, dest, load, false)
+		if err != nil {
+			qb.queryBuilder.Warn("cache refresh failed: "+err.Error(), time.Now(), key)
+		}
+		return data, found, err
+	})
+}
+
+// loadCached выполняет запрос и сохраняет результат в кеш.
+// Если кеш поддерживает блокировки, запрос выполняет только держатель блокировки,
+// остальные при wait ждут появления значения
+func (qb *Builder) loadCached(ctx context.Context, key string, dest any, load func(ctx context.Context, dest any) (bool, error), wait bool) ([]byte, bool, error) {
+	q := qb.queryBuilder
+	if locker, ok := q.cache.(CacheLocker); ok {
+		lockKey := key + ":lock"
+		if token, ok := locker.TryLock(lockKey, q.cacheLockTTL()); ok {
+			defer locker.Unlock(lockKey, token)
+		} else if !wait {
+			return nil, false, nil
+		} else if entry, ok := q.waitEntry(ctx, key); ok {
+			return entry.Value, true, nil
+		}
+	}
+
+	value := reflect.New(reflect.TypeOf(dest).Elem())
+	start := time.Now()
+	found, err := load(ctx, value.Interface())
+	if err != nil || !found {
+		return nil, found, err
+	}
+	data, err := json.Marshal(value.Interface())
+	if err != nil {
+		return nil, false, err
+	}
+
+	entry := cacheEntry{
+		Value:   data,
+		Expires: time.Now().Add(qb.cacheDuration).UnixNano(),
+		Delta:   int64(time.Since(start)),
+	}
+	if encoded, err := json.Marshal(entry); err == nil {
+		q.cacheSet(ctx, key, string(encoded), qb.cacheDuration+q.cacheOpts.StaleWhileRevalidate)
+	}
+	return data, true, nil
+}
+
+// readEntry читает значение из кеша.
+// Значения, записанные без обертки, считаются не имеющими срока истечения
+func (q *QueryBuilder) readEntry(ctx context.Context, key string) (cacheEntry, bool) {
+	cached, ok := q.cacheGet(ctx, key)
+	if !ok {
+		return cacheEntry{}, false
+	}
+	var raw []byte
+	switch v := cached.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return cacheEntry{}, false
+		}
+	}
+
+	var entry cacheEntry
+	if json.Unmarshal(raw, &entry) == nil && entry.Expires > 0 && entry.Value != nil {
+		return entry, true
+	}
+	return cacheEntry{Value: raw}, true
+}
+
+// cacheGet читает значение из кеша, передавая контекст, если кеш его поддерживает
+func (q *QueryBuilder) cacheGet(ctx context.Context, key string) (any, bool) {
+	if cc, ok := q.cache.(CacheContextInterface); ok {
+		return cc.GetContext(ctx, key)
+	}
+	return q.cache.Get(key)
+}
+
+// cacheSet записывает значение в кеш, передавая контекст, если кеш его поддерживает
+func (q *QueryBuilder) cacheSet(ctx context.Context, key string, value any, expiration time.Duration) {
+	if cc, ok := q.cache.(CacheContextInterface); ok {
+		cc.SetContext(ctx, key, value, expiration)
+		return
+	}
+	q.cache.Set(key, value, expiration)
+}
+
+// waitEntry ждет, пока держатель блокировки запишет свежее значение
+func (q *QueryBuilder) waitEntry(ctx context.Context, key string) (cacheEntry, bool) {
+	deadline := time.Now().Add(q.cacheLockTTL())
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return cacheEntry{}, false
+		case <-time.After(25 * time.Millisecond):
+		}
+		if entry, ok := q.readEntry(ctx, key); ok && (entry.Expires == 0 || time.Now().UnixNano() < entry.Expires) {
+			return entry, true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+// refreshEarly решает, обновить ли значение до истечения срока (XFetch):
+// вероятность растет по мере приближения к сроку и с временем вычисления
+func (q *QueryBuilder) refreshEarly(entry cacheEntry, now int64) bool {
+	beta := q.cacheOpts.EarlyRefresh
+	if beta <= 0 || entry.Expires == 0 || entry.Delta <= 0 {
+		return false
+	}
+	return float64(now)-float64(entry.Delta)*beta*math.Log(rand.Float64()) >= float64(entry.Expires)
+}
+
+// flightGroup объединяет одновременные вызовы с одинаковым ключом
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	data  []byte
+	found bool
+	err   error
+}
+
+// do выполняет fn один раз для всех одновременных вызовов с ключом.
+// fn выполняется в фоне, каждый вызов ждет результата не дольше своего ctx
+func (g *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, bool, error)) ([]byte, bool, error) {
+	c := g.start(key, fn)
+	select {
+	case <-c.done:
+		return c.data, c.found, c.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// start запускает fn в фоне, если вызов с ключом еще не выполняется,
+// и возвращает выполняющийся вызов
+func (g *flightGroup) start(key string, fn func() ([]byte, bool, error)) *flightCall {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		return c
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	go func() {
+		defer g.finish(key, c)
+		c.data, c.found, c.err = fn()
+	}()
+	return c
+}
+
+func (g *flightGroup) finish(key string, c *flightCall) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// invalidateAfterWrite сбрасывает кеш таблицы после успешной записи,
+// в транзакции — после ее фиксации. Таблица берется из SQL вместе со схемой,
+// как и в ключах закешированных чтений
+func (q *QueryBuilder) invalidateAfterWrite(tx *Transaction, event QueryEvent) {
+	if event.Err != nil || !isWriteOperation(event.Operation) {
+		return
+	}
+	table := normalizeTable(queryTable(event.SQL))
+	if table == "" {
+		table = normalizeTable(event.Table)
+	}
+	if table == "" {
+		return
+	}
+	if tx != nil {
+		tx.deferInvalidate(table)
+		return
+	}
+	q.invalidateTables(table)
+}
+
+// tableGeneration возвращает текущее поколение таблицы, создавая его при отсутствии.
+// Поколение создается один раз: иначе одновременные промахи получили бы разные поколения,
+// а с ними разные ключи, и каждый выполнил бы запрос. Между процессами создание
+// защищено блокировкой кеша, если он поддерживает CacheLocker
+func (q *QueryBuilder) tableGeneration(table string) string {
+	if gen, ok := q.currentGeneration(table); ok {
+		return gen
+	}
+	// Блокировка своя у каждой таблицы, чтобы ожидание CacheLocker
+	// не задерживало чтения других таблиц
+	mu, _ := q.generations.LoadOrStore(table, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	if gen, ok := q.currentGeneration(table); ok {
+		return gen
+	}
+	if locker, ok := q.cache.(CacheLocker); ok {
+		lockKey := cacheTagPrefix + table + ":lock"
+		deadline := time.Now().Add(q.cacheLockTTL())
+		token, locked := locker.TryLock(lockKey, q.cacheLockTTL())
+		for !locked {
+			if time.Now().After(deadline) {
+				return q.bumpGeneration(table)
+			}
+			time.Sleep(5 * time.Millisecond)
+			if gen, ok := q.currentGeneration(table); ok {
+				return gen
+			}
+			token, locked = locker.TryLock(lockKey, q.cacheLockTTL())
+		}
+		defer locker.Unlock(lockKey, token)
+		if gen, ok := q.currentGeneration(table); ok {
+			return gen
+		}
+	}
+	return q.bumpGeneration(table)
+}
+
+// currentGeneration возвращает сохраненное поколение таблицы
+func (q *QueryBuilder) currentGeneration(table string) (string, bool) {
+	if gen, ok := q.cache.Get(cacheTagPrefix + table); ok {
+		if s := fmt.Sprint(gen); s != "" {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// invalidateTables делает недействительными закешированные запросы к таблицам
+func (q *QueryBuilder) invalidateTables(tables ...string) {
+	if q.cache == nil {
+		return
+	}
+	for _, table := range tables {
+		q.bumpGeneration(table)
+	}
+}
+
+func (q *QueryBuilder) bumpGeneration(table string) string {
+	gen := strconv.FormatInt(time.Now().UnixNano(), 36)
+	q.cache.Set(cacheTagPrefix+table, gen, cacheTagTTL)
+	return gen
+}
+
+// deferInvalidate откладывает сброс кеша таблицы до фиксации транзакции
+func (t *Transaction) deferInvalidate(table string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.invalidate == nil {
+		t.invalidate = make(map[string]struct{})
+	}
+	t.invalidate[table] = struct{}{}
+}
+
+// flushInvalidate сбрасывает кеш таблиц, измененных в транзакции
+func (t *Transaction) flushInvalidate() {
+	t.mu.Lock()
+	tables := make([]string, 0, len(t.invalidate))
+	for table := range t.invalidate {
+		tables = append(tables, table)
+	}
+	t.invalidate = nil
+	t.mu.Unlock()
+	t.QueryBuilder.invalidateTables(tables...)
+}
+
+// queryTables возвращает отсортированный список таблиц из FROM и JOIN запроса
+func queryTables(query string) []string {
+	seen := make(map[string]struct{})
+	var tables []string
+	for _, m := range queryTablesRe.FindAllStringSubmatch(query, -1) {
+		table := normalizeTable(m[1])
+		if _, ok := seen[table]; ok || table == "" {
+			continue
+		}
+		seen[table] = struct{}{}
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// normalizeTable убирает псевдоним и кавычки из имени таблицы
+func normalizeTable(table string) string {
+	if fields := strings.Fields(table); len(fields) > 0 {
+		table = fields[0]
+	}
+	return strings.ToLower(strings.NewReplacer(`"`, "", "`", "").Replace(table))
+}
+
+// isWriteOperation проверяет, изменяет ли операция данные
+func isWriteOperation(operation string) bool {
+	switch operation {
+	case "insert", "update", "delete", "replace", "truncate", "merge":
+		return true
+	}
+	return false
+}
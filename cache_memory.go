@@ -1,78 +1,426 @@
 package qb
 
 import (
+	"container/list"
+	"encoding/json"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultMemoryCacheMaxEntries ограничение кеша в памяти, создаваемого по умолчанию в New и NewX
+const DefaultMemoryCacheMaxEntries = 10000
+
+// EvictionPolicy политика вытеснения записей при превышении лимитов
+type EvictionPolicy int
+
+const (
+	// EvictLRU вытесняет давно не использованные записи
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU вытесняет редко используемые записи, при равенстве — давно не использованные
+	EvictLFU
+)
+
+// MemoryCacheOptions настройки кеша в памяти
+type MemoryCacheOptions struct {
+	// MaxEntries максимальное число записей, 0 — без ограничения
+	MaxEntries int
+	// MaxBytes максимальный оценочный размер ключей и значений, 0 — без ограничения
+	MaxBytes int64
+	// Policy политика вытеснения, по умолчанию EvictLRU
+	Policy EvictionPolicy
+	// Shards число сегментов с отдельными блокировками, по умолчанию 16
+	Shards int
+	// CleanupInterval период фоновой очистки истекших записей, 0 — без фоновой очистки.
+	// Истекшие записи в любом случае удаляются при обращении и вытеснении
+	CleanupInterval time.Duration
+}
+
+// MemoryCacheStats счетчики кеша в памяти
+type MemoryCacheStats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+	Entries     int
+	Bytes       int64
+}
+
 // MemoryCache реализует кеш в памяти
 type MemoryCache struct {
-	data map[string]cacheItem
-	mu   sync.RWMutex
+	shards []*memoryShard
+	policy EvictionPolicy
+
+	locksMu sync.Mutex
+	locks   map[string]memoryLock
+
+	hits        atomic.Int64
+	misses      atomic.Int64
+	evictions   atomic.Int64
+	expirations atomic.Int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// memoryShard сегмент кеша со своей блокировкой и лимитами
+type memoryShard struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	freqs      map[int]*list.List
+	minFreq    int
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
 }
-type cacheItem struct {
+
+type memoryEntry struct {
+	key        string
 	value      any
 	expiration time.Time
+	size       int64
+	freq       int
+}
+
+// memoryLock блокировка TryLock с токеном владельца
+type memoryLock struct {
+	token string
+	until time.Time
 }
 
+// NewCacheMemory создает неограниченный кеш в памяти с очисткой раз в минуту.
+// Фоновая очистка останавливается через Close
 func NewCacheMemory() *MemoryCache {
-	cache := &MemoryCache{
-		data: make(map[string]cacheItem),
+	return NewMemoryCache(MemoryCacheOptions{CleanupInterval: time.Minute})
+}
+
+// NewMemoryCache создает кеш в памяти с заданными лимитами
+func NewMemoryCache(opts MemoryCacheOptions) *MemoryCache {
+	if opts.Shards <= 0 {
+		opts.Shards = 16
+	}
+	// Каждому сегменту нужен хотя бы один элемент лимита, иначе сегмент окажется неограниченным
+	if opts.MaxEntries > 0 && opts.Shards > opts.MaxEntries {
+		opts.Shards = opts.MaxEntries
+	}
+	if opts.MaxBytes > 0 && int64(opts.Shards) > opts.MaxBytes {
+		opts.Shards = int(opts.MaxBytes)
+	}
+	c := &MemoryCache{
+		shards: make([]*memoryShard, opts.Shards),
+		policy: opts.Policy,
+		locks:  make(map[string]memoryLock),
+		done:   make(chan struct{}),
+	}
+	for i := range c.shards {
+		c.shards[i] = &memoryShard{
+			items:      make(map[string]*list.Element),
+			lru:        list.New(),
+			freqs:      make(map[int]*list.List),
+			maxEntries: int(shardLimit(int64(opts.MaxEntries), opts.Shards, i)),
+			maxBytes:   shardLimit(opts.MaxBytes, opts.Shards, i),
+		}
+	}
+	if opts.CleanupInterval > 0 {
+		go c.startCleanup(opts.CleanupInterval)
 	}
-	go cache.startCleanup()
-	return cache
+	return c
 }
 
 func (c *MemoryCache) Get(key string) (any, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	item, exists := c.data[key]
+	el, exists := s.items[key]
 	if !exists {
+		c.misses.Add(1)
 		return nil, false
 	}
-
-	if time.Now().After(item.expiration) {
-		go c.Delete(key)
+	entry := el.Value.(*memoryEntry)
+	if entry.expired(time.Now()) {
+		s.remove(el, c.policy)
+		c.expirations.Add(1)
+		c.misses.Add(1)
 		return nil, false
 	}
 
-	return item.value, true
+	s.touch(el, c.policy)
+	c.hits.Add(1)
+	return entry.value, true
 }
 
+// Set сохраняет значение. Нулевой или отрицательный expiration означает хранение без срока
 func (c *MemoryCache) Set(key string, value any, expiration time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	entry := &memoryEntry{
+		key:   key,
+		value: value,
+		size:  int64(len(key)) + estimateSize(value),
+		freq:  1,
+	}
+	if expiration > 0 {
+		entry.expiration = time.Now().Add(expiration)
+	}
+
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, exists := s.items[key]; exists {
+		entry.freq = el.Value.(*memoryEntry).freq
+		s.remove(el, c.policy)
+	}
+	// Запись больше лимита сегмента не сохраняется
+	if s.maxBytes > 0 && entry.size > s.maxBytes {
+		return
+	}
 
-	c.data[key] = cacheItem{
-		value:      value,
-		expiration: time.Now().Add(expiration),
+	now := time.Now()
+	for s.overflow(entry.size) {
+		victim := s.victim(c.policy)
+		if victim == nil {
+			break
+		}
+		if victim.Value.(*memoryEntry).expired(now) {
+			c.expirations.Add(1)
+		} else {
+			c.evictions.Add(1)
+		}
+		s.remove(victim, c.policy)
 	}
+	s.add(entry, c.policy)
 }
 
 func (c *MemoryCache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.data, key)
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, exists := s.items[key]; exists {
+		s.remove(el, c.policy)
+	}
 }
 
 func (c *MemoryCache) Clear() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.data = make(map[string]cacheItem)
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.freqs = make(map[int]*list.List)
+		s.minFreq = 0
+		s.bytes = 0
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Stats возвращает счетчики кеша
+func (c *MemoryCache) Stats() MemoryCacheStats {
+	stats := MemoryCacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+	}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		stats.Entries += len(s.items)
+		stats.Bytes += s.bytes
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// Close останавливает фоновую очистку
+func (c *MemoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
 	return nil
 }
 
-func (c *MemoryCache) startCleanup() {
-	ticker := time.NewTicker(time.Minute)
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now()
-		for key, item := range c.data {
-			if now.After(item.expiration) {
-				delete(c.data, key)
+// TryLock захватывает блокировку на время ttl, если она свободна
+func (c *MemoryCache) TryLock(key string, ttl time.Duration) (string, bool) {
+	token, err := newLockToken()
+	if err != nil {
+		return "", false
+	}
+	c.locksMu.Lock()
+	defer c.locksMu.Unlock()
+
+	now := time.Now()
+	if lock, ok := c.locks[key]; ok && now.Before(lock.until) {
+		return "", false
+	}
+	c.locks[key] = memoryLock{token: token, until: now.Add(ttl)}
+	return token, true
+}
+
+// Unlock освобождает блокировку, если она еще принадлежит токену из TryLock
+func (c *MemoryCache) Unlock(key string, token string) {
+	c.locksMu.Lock()
+	defer c.locksMu.Unlock()
+	if c.locks[key].token == token {
+		delete(c.locks, key)
+	}
+}
+
+func (c *MemoryCache) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.cleanup()
+		}
+	}
+}
+
+// cleanup удаляет истекшие записи и блокировки
+func (c *MemoryCache) cleanup() {
+	now := time.Now()
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, el := range s.items {
+			if el.Value.(*memoryEntry).expired(now) {
+				s.remove(el, c.policy)
+				c.expirations.Add(1)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	c.locksMu.Lock()
+	for key, lock := range c.locks {
+		if now.After(lock.until) {
+			delete(c.locks, key)
+		}
+	}
+	c.locksMu.Unlock()
+}
+
+func (c *MemoryCache) shard(key string) *memoryShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiration.IsZero() && now.After(e.expiration)
+}
+
+// overflow проверяет, превысит ли новая запись лимиты сегмента
+func (s *memoryShard) overflow(size int64) bool {
+	if len(s.items) == 0 {
+		return false
+	}
+	return s.maxEntries > 0 && len(s.items) >= s.maxEntries ||
+		s.maxBytes > 0 && s.bytes+size > s.maxBytes
+}
+
+func (s *memoryShard) add(entry *memoryEntry, policy EvictionPolicy) {
+	if policy == EvictLFU {
+		s.items[entry.key] = s.freqList(entry.freq).PushFront(entry)
+		if s.minFreq == 0 || entry.freq < s.minFreq {
+			s.minFreq = entry.freq
+		}
+	} else {
+		s.items[entry.key] = s.lru.PushFront(entry)
+	}
+	s.bytes += entry.size
+}
+
+func (s *memoryShard) remove(el *list.Element, policy EvictionPolicy) {
+	entry := el.Value.(*memoryEntry)
+	if policy == EvictLFU {
+		l := s.freqs[entry.freq]
+		l.Remove(el)
+		if l.Len() == 0 {
+			delete(s.freqs, entry.freq)
+			if s.minFreq == entry.freq {
+				s.minFreq = s.lowestFreq()
 			}
 		}
-		c.mu.Unlock()
+	} else {
+		s.lru.Remove(el)
+	}
+	delete(s.items, entry.key)
+	s.bytes -= entry.size
+}
+
+// touch отмечает обращение к записи
+func (s *memoryShard) touch(el *list.Element, policy EvictionPolicy) {
+	if policy != EvictLFU {
+		s.lru.MoveToFront(el)
+		return
+	}
+	entry := el.Value.(*memoryEntry)
+	s.remove(el, policy)
+	entry.freq++
+	s.add(entry, policy)
+}
+
+// victim возвращает запись для вытеснения по политике
+func (s *memoryShard) victim(policy EvictionPolicy) *list.Element {
+	if policy == EvictLFU {
+		if l, ok := s.freqs[s.minFreq]; ok {
+			return l.Back()
+		}
+		return nil
+	}
+	return s.lru.Back()
+}
+
+func (s *memoryShard) freqList(freq int) *list.List {
+	l, ok := s.freqs[freq]
+	if !ok {
+		l = list.New()
+		s.freqs[freq] = l
+	}
+	return l
+}
+
+func (s *memoryShard) lowestFreq() int {
+	lowest := 0
+	for freq := range s.freqs {
+		if lowest == 0 || freq < lowest {
+			lowest = freq
+		}
+	}
+	return lowest
+}
+
+// estimateSize оценивает размер значения в байтах
+func estimateSize(value any) int64 {
+	switch v := value.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case nil:
+		return 0
+	}
+	if data, err := json.Marshal(value); err == nil {
+		return int64(len(data))
+	}
+	return 64
+}
+
+// shardLimit делит общий лимит между сегментами так, чтобы сумма лимитов сегментов
+// равнялась общему: первые total % shards сегментов получают на единицу больше
+func shardLimit(total int64, shards int, i int) int64 {
+	if total <= 0 {
+		return 0
+	}
+	limit := total / int64(shards)
+	if int64(i) < total%int64(shards) {
+		limit++
 	}
+	return limit
 }
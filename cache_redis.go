@@ -1,16 +1,88 @@
 package qb
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// DefaultRedisCachePrefix пространство имен ключей кеша по умолчанию
+const DefaultRedisCachePrefix = "qb:cache:"
+
+// CacheCodec сериализует значения кеша.
+// Unmarshal должен поддерживать как *any, так и указатель на конкретный тип
+type CacheCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec сериализация в JSON, используется по умолчанию
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec сериализация в gob с сохранением типов.
+// Собственные типы должны быть зарегистрированы через gob.Register
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	var value any
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return err
+	}
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("qb: gob codec needs a non-nil pointer, got %T", v)
+	}
+	if value == nil {
+		target.Elem().SetZero()
+		return nil
+	}
+	src := reflect.ValueOf(value)
+	if !src.Type().AssignableTo(target.Elem().Type()) {
+		return fmt.Errorf("qb: cached %s is not assignable to %s", src.Type(), target.Elem().Type())
+	}
+	target.Elem().Set(src)
+	return nil
+}
+
+// RedisCacheOptions настройки кеша в Redis
+type RedisCacheOptions struct {
+	// Prefix пространство имен ключей, по умолчанию DefaultRedisCachePrefix.
+	// Clear удаляет только ключи с этим префиксом
+	Prefix string
+	// Codec сериализация значений, по умолчанию JSONCodec.
+	// Для msgpack и других форматов достаточно реализовать CacheCodec
+	Codec CacheCodec
+}
+
+// RedisCache реализует кеш в Redis в отдельном пространстве имен
 type RedisCache struct {
-	client *redis.Client
+	client redis.UniversalClient
 	ctx    context.Context
+	prefix string
+	codec  CacheCodec
 }
 
 func NewCacheRedis(addr string, password string, db int) *RedisCache {
@@ -20,39 +92,203 @@ func NewCacheRedis(addr string, password string, db int) *RedisCache {
 		DB:       db,
 	})
 
+	return NewRedisCache(client, RedisCacheOptions{})
+}
+
+// NewRedisCache создает кеш поверх готового клиента Redis
+func NewRedisCache(client redis.UniversalClient, opts RedisCacheOptions) *RedisCache {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultRedisCachePrefix
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec{}
+	}
 	return &RedisCache{
 		client: client,
 		ctx:    context.Background(),
+		prefix: opts.Prefix,
+		codec:  opts.Codec,
 	}
 }
 
 func (c *RedisCache) Get(key string) (any, bool) {
-	val, err := c.client.Get(c.ctx, key).Result()
-	if err != nil {
-		return nil, false
-	}
+	return c.GetContext(c.ctx, key)
+}
 
+func (c *RedisCache) Set(key string, value any, expiration time.Duration) {
+	c.SetContext(c.ctx, key, value, expiration)
+}
+
+func (c *RedisCache) Delete(key string) {
+	c.DeleteContext(c.ctx, key)
+}
+
+func (c *RedisCache) Clear() error {
+	return c.ClearContext(c.ctx)
+}
+
+// GetContext получает значение с контекстом
+func (c *RedisCache) GetContext(ctx context.Context, key string) (any, bool) {
 	var result any
-	if err := json.Unmarshal([]byte(val), &result); err != nil {
+	found, err := c.GetInto(ctx, key, &result)
+	if err != nil || !found {
 		return nil, false
 	}
-
 	return result, true
 }
 
-func (c *RedisCache) Set(key string, value any, expiration time.Duration) {
-	data, err := json.Marshal(value)
+// GetInto получает значение и декодирует его в dest без потери типа
+func (c *RedisCache) GetInto(ctx context.Context, key string, dest any) (bool, error) {
+	data, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
 	if err != nil {
-		return
+		return false, err
+	}
+	if err := c.codec.Unmarshal(data, dest); err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	c.client.Set(c.ctx, key, data, expiration)
+// getWithTTL получает значение вместе с оставшимся временем жизни ключа,
+// 0 — ключ без срока жизни
+func (c *RedisCache) getWithTTL(ctx context.Context, key string) (any, time.Duration, bool) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, c.key(key))
+		pttl = pipe.PTTL(ctx, c.key(key))
+		return nil
+	})
+	if err != nil {
+		return nil, 0, false
+	}
+	data, err := get.Bytes()
+	if err != nil {
+		return nil, 0, false
+	}
+	var value any
+	if err := c.codec.Unmarshal(data, &value); err != nil {
+		return nil, 0, false
+	}
+	return value, max(pttl.Val(), 0), true
 }
 
-func (c *RedisCache) Delete(key string) {
-	c.client.Del(c.ctx, key)
+// SetContext сохраняет значение с контекстом
+func (c *RedisCache) SetContext(ctx context.Context, key string, value any, expiration time.Duration) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.key(key), data, expiration).Err()
 }
 
-func (c *RedisCache) Clear() error {
-	return c.client.FlushDB(c.ctx).Err()
+// DeleteContext удаляет значение с контекстом
+func (c *RedisCache) DeleteContext(ctx context.Context, key string) error {
+	return c.client.Unlink(ctx, c.key(key)).Err()
+}
+
+// ClearContext удаляет все ключи пространства имен через SCAN и UNLINK,
+// не затрагивая чужие ключи в той же базе
+func (c *RedisCache) ClearContext(ctx context.Context) error {
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return c.clearNode(ctx, node)
+		})
+	}
+	return c.clearNode(ctx, c.client)
+}
+
+func (c *RedisCache) clearNode(ctx context.Context, client redis.Cmdable) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, c.prefix+"*", 500).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// tagScript добавляет ключ в набор тега и продлевает набор не меньше чем на время жизни ключа
+var tagScript = redis.NewScript(`
+local current = redis.call("PTTL", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+elseif current == -2 or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1`)
+
+// SetWithTags сохраняет значение и добавляет его в наборы тегов для группового сброса
+func (c *RedisCache) SetWithTags(ctx context.Context, key string, value any, expiration time.Duration, tags ...string) error {
+	if err := c.SetContext(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		err := tagScript.Run(ctx, c.client, []string{c.tagKey(tag)}, c.key(key), expiration.Milliseconds()).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTags удаляет все значения, сохраненные с указанными тегами
+func (c *RedisCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		tagKey := c.tagKey(tag)
+		keys, err := c.client.SMembers(ctx, tagKey).Result()
+		if err != nil {
+			return err
+		}
+		// В кластере ключи могут лежать в разных слотах, поэтому удаляются по одному
+		for _, key := range append(keys, tagKey) {
+			if err := c.client.Unlink(ctx, key).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unlockScript удаляет блокировку, только если она принадлежит нам
+var unlockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+// TryLock захватывает короткую блокировку через SET NX
+func (c *RedisCache) TryLock(key string, ttl time.Duration) (string, bool) {
+	token, err := newLockToken()
+	if err != nil {
+		return "", false
+	}
+	ok, err := c.client.SetNX(c.ctx, c.key(key), token, ttl).Result()
+	if err != nil || !ok {
+		return "", false
+	}
+	return token, true
+}
+
+// Unlock освобождает блокировку, если она еще принадлежит токену из TryLock
+func (c *RedisCache) Unlock(key string, token string) {
+	unlockScript.Run(c.ctx, c.client, []string{c.key(key)}, token)
+}
+
+func (c *RedisCache) key(key string) string {
+	return c.prefix + key
+}
+
+func (c *RedisCache) tagKey(tag string) string {
+	return c.prefix + "tag:" + tag
 }
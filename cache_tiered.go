@@ -0,0 +1,200 @@
+package qb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTieredL1TTL максимальное время жизни записи в L1 по умолчанию
+const DefaultTieredL1TTL = 30 * time.Second
+
+// TieredCacheOptions настройки двухуровневого кеша
+type TieredCacheOptions struct {
+	// L1TTL максимальное время жизни записи в памяти процесса, по умолчанию DefaultTieredL1TTL.
+	// Ограничивает устаревание L1, если сообщение об инвалидации потерялось
+	L1TTL time.Duration
+	// Channel канал pub/sub для инвалидаций, по умолчанию префикс L2 + "invalidate"
+	Channel string
+}
+
+// TieredCache двухуровневый кеш: MemoryCache (L1) перед RedisCache (L2).
+// Изменения рассылаются через pub/sub Redis, чтобы другие узлы сбросили свой L1
+type TieredCache struct {
+	l1      *MemoryCache
+	l2      *RedisCache
+	l1TTL   time.Duration
+	channel string
+	node    string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// tieredMessage сообщение об инвалидации L1
+type tieredMessage struct {
+	Node string   `json:"node"`
+	Keys []string `json:"keys,omitempty"`
+	All  bool     `json:"all,omitempty"`
+}
+
+// NewTieredCache создает двухуровневый кеш и подписывается на инвалидации.
+// Подписка останавливается через Close
+func NewTieredCache(l1 *MemoryCache, l2 *RedisCache, opts TieredCacheOptions) *TieredCache {
+	if opts.L1TTL <= 0 {
+		opts.L1TTL = DefaultTieredL1TTL
+	}
+	if opts.Channel == "" {
+		opts.Channel = l2.prefix + "invalidate"
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Идентификатор узла нужен только для пропуска своих сообщений, уникальности по времени достаточно
+		binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &TieredCache{
+		l1:      l1,
+		l2:      l2,
+		l1TTL:   opts.L1TTL,
+		channel: opts.Channel,
+		node:    hex.EncodeToString(b),
+		cancel:  cancel,
+	}
+	pubsub := l2.client.Subscribe(ctx, c.channel)
+	c.wg.Add(1)
+	go c.listen(ctx, pubsub.Channel(), pubsub.Close)
+	return c
+}
+
+func (c *TieredCache) Get(key string) (any, bool) {
+	return c.GetContext(c.l2.ctx, key)
+}
+
+func (c *TieredCache) Set(key string, value any, expiration time.Duration) {
+	c.SetContext(c.l2.ctx, key, value, expiration)
+}
+
+func (c *TieredCache) Delete(key string) {
+	c.DeleteContext(c.l2.ctx, key)
+}
+
+func (c *TieredCache) Clear() error {
+	return c.ClearContext(c.l2.ctx)
+}
+
+// GetContext ищет значение в L1, затем в L2, заполняя L1 при попадании в L2
+func (c *TieredCache) GetContext(ctx context.Context, key string) (any, bool) {
+	if value, ok := c.l1.Get(key); ok {
+		return value, true
+	}
+	value, ttl, ok := c.l2.getWithTTL(ctx, key)
+	if !ok {
+		return nil, false
+	}
+	// Запись в L1 не должна пережить запись в L2
+	c.l1.Set(key, value, c.boundTTL(ttl))
+	return value, true
+}
+
+// SetContext записывает значение в оба уровня и рассылает инвалидацию
+func (c *TieredCache) SetContext(ctx context.Context, key string, value any, expiration time.Duration) error {
+	if err := c.l2.SetContext(ctx, key, value, expiration); err != nil {
+		c.l1.Delete(key)
+		return err
+	}
+	// В L1 хранится то же представление, что вернет L2 на других узлах
+	if data, err := c.l2.codec.Marshal(value); err == nil {
+		var decoded any
+		if c.l2.codec.Unmarshal(data, &decoded) == nil {
+			value = decoded
+		}
+	}
+	c.l1.Set(key, value, c.boundTTL(expiration))
+	return c.publish(ctx, tieredMessage{Keys: []string{key}})
+}
+
+// DeleteContext удаляет значение из обоих уровней и рассылает инвалидацию
+func (c *TieredCache) DeleteContext(ctx context.Context, key string) error {
+	c.l1.Delete(key)
+	if err := c.l2.DeleteContext(ctx, key); err != nil {
+		return err
+	}
+	return c.publish(ctx, tieredMessage{Keys: []string{key}})
+}
+
+// ClearContext очищает оба уровня и L1 на остальных узлах
+func (c *TieredCache) ClearContext(ctx context.Context) error {
+	c.l1.Clear()
+	if err := c.l2.ClearContext(ctx); err != nil {
+		return err
+	}
+	return c.publish(ctx, tieredMessage{All: true})
+}
+
+// TryLock захватывает блокировку в L2, общую для всех узлов
+func (c *TieredCache) TryLock(key string, ttl time.Duration) (string, bool) {
+	return c.l2.TryLock(key, ttl)
+}
+
+// Unlock освобождает блокировку в L2
+func (c *TieredCache) Unlock(key string, token string) {
+	c.l2.Unlock(key, token)
+}
+
+// Close останавливает подписку на инвалидации
+func (c *TieredCache) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	return nil
+}
+
+func (c *TieredCache) boundTTL(expiration time.Duration) time.Duration {
+	if expiration <= 0 || expiration > c.l1TTL {
+		return c.l1TTL
+	}
+	return expiration
+}
+
+func (c *TieredCache) publish(ctx context.Context, msg tieredMessage) error {
+	msg.Node = c.node
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.l2.client.Publish(ctx, c.channel, data).Err()
+}
+
+// listen сбрасывает L1 по сообщениям других узлов
+func (c *TieredCache) listen(ctx context.Context, messages <-chan *redis.Message, closeSub func() error) {
+	defer c.wg.Done()
+	defer closeSub()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-messages:
+			if !ok {
+				return
+			}
+			var msg tieredMessage
+			if json.Unmarshal([]byte(m.Payload), &msg) != nil || msg.Node == c.node {
+				continue
+			}
+			if msg.All {
+				c.l1.Clear()
+				continue
+			}
+			for _, key := range msg.Keys {
+				c.l1.Delete(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package qb
+
+import "context"
+
+// QueryHook расширение вокруг выполнения запросов.
+// BeforeQuery вызывается до выполнения и может вернуть новый контекст,
+// который будет передан драйверу и в AfterQuery.
+type QueryHook interface {
+	BeforeQuery(ctx context.Context, event QueryEvent) context.Context
+	AfterQuery(ctx context.Context, event QueryEvent)
+}
+
+// AddHook регистрирует хук для всех запросов, включая транзакции и сырые запросы.
+// Хуки регистрируются до начала работы с базой.
+func (q *QueryBuilder) AddHook(hook QueryHook) {
+	q.hooks = append(q.hooks, hook)
+}
+
+// beforeQuery вызывает хуки перед выполнением запроса.
+// Ошибка означает, что запрос выполнять не нужно.
+func (q *QueryBuilder) beforeQuery(ctx context.Context, event QueryEvent) (context.Context, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for _, hook := range q.hooks {
+		if next := hook.BeforeQuery(ctx, event); next != nil {
+			ctx = next
+		}
+	}
+	return ctx, q.detectNPlusOne(ctx, event)
+}
@@ -18,6 +18,7 @@ type DBInterface interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
 
 	Beginx() (*sqlx.Tx, error)
 	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
@@ -32,6 +33,7 @@ type TxInterface interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
 
 	Commit() error
 	Rollback() error
@@ -43,6 +45,33 @@ type QueryBuilderInterface interface {
 	Raw(query string, args ...any) *RawQuery
 	GetDB() DBInterface
 	SetLogger(logger *slog.Logger)
+	AddHook(hook QueryHook)
+	SetSlowQueryLog(opts SlowQueryOptions)
+	SetRedaction(policy RedactionPolicy)
+	SetNPlusOneDetector(opts NPlusOneOptions)
+	SetCacheOptions(opts CacheOptions)
+	SetReplicaOptions(opts ReplicaOptions)
+	SetTenantMode(opts TenantOptions)
+	SetSchemaOptions(opts SchemaOptions)
+	ForSchema(name string) *SchemaQuery
+	ForEachSchema(ctx context.Context, fn func(ctx context.Context, schema *SchemaQuery) error) error
+	AddGlobalScope(table string, name string, scope Scope)
+	UseSoftDeletes(tables ...string)
+	SetSoftDeleteOptions(table string, opts SoftDeleteOptions)
+	AddRelation(table string, name string, rel Relation)
+	AddModelRelations(table string, model any) error
+	SetVersionColumn(table string, column string)
+	SetVersionPrecision(precision time.Duration)
+
+	// Метрики
+	SetMetrics(collector *MetricsCollector)
+	GetMetrics() *MetricsCollector
+	PrometheusExporter() *PrometheusExporter
+
+	// Аудит
+	SetAuditTable(table string)
+	SetAuditSink(sink AuditSink)
+	SetPrimaryKey(table string, column string)
 
 	// Транзакции
 	Begin() (*Transaction, error)
@@ -50,6 +79,13 @@ type QueryBuilderInterface interface {
 	Transaction(fn func(*Transaction) error) error
 	TransactionContext(ctx context.Context, fn func(*Transaction) error) error
 
+	// Очереди
+	JobQueue(opts QueueOptions) *JobQueue
+
+	// Outbox
+	SetOutboxTable(table string)
+	OutboxRelay(publisher Publisher, opts OutboxRelayOptions) *OutboxRelay
+
 	// Логирование
 	Debug(msg string, start time.Time, query string, args ...any)
 	Info(msg string, start time.Time, query string, args ...any)
@@ -156,11 +192,29 @@ type BuilderInterface interface {
 	// Полнотекстовый поиск
 	Search(columns []string, query string) *Builder
 
+	// Скоупы
+	Scope(scopes ...Scope) *Builder
+	WithoutScope(names ...string) *Builder
+	WithoutGlobalScopes() *Builder
+
+	// Связи
+	With(relations ...string) *Builder
+	WithQuery(relation string, fn func(*Builder)) *Builder
+	WhereHas(relation string, fn func(*Builder), minCount int) *Builder
+	WhereDoesntHave(relation string, fn func(*Builder)) *Builder
+	WithCount(relation string) *Builder
+	WithSum(relation string, column string) *Builder
+
+	// Отслеживаемые модели
+	Save(ctx context.Context, model any) error
+
 	// Soft Delete
 	WithTrashed() *Builder
 	OnlyTrashed() *Builder
 	SoftDelete() error
 	Restore() error
+	ForceDelete() error
+	PurgeTrashed(olderThan time.Duration) error
 
 	// Аудит
 	WithAudit(userID any) *Builder
@@ -173,6 +227,12 @@ type BuilderInterface interface {
 
 	// Метрики
 	WithMetrics(collector *MetricsCollector) *Builder
+	// Кеширование
+	Cache(ttl time.Duration) *Builder
+	// Чтение с основной базы при наличии реплик
+	UsePrimary() *Builder
+	// Отключение фильтрации по арендатору
+	WithoutTenant() *Builder
 	// События
 	On(event EventType, handler EventHandler)
 	Trigger(event EventType, data any)
@@ -227,6 +287,23 @@ type CacheInterface interface {
 	Clear() error
 }
 
+// CacheContextInterface реализуется кешами с методами, принимающими контекст
+type CacheContextInterface interface {
+	GetContext(ctx context.Context, key string) (any, bool)
+	SetContext(ctx context.Context, key string, value any, expiration time.Duration) error
+	DeleteContext(ctx context.Context, key string) error
+	ClearContext(ctx context.Context) error
+}
+
+// CacheLocker реализуется кешами с короткими блокировками,
+// чтобы значение обновлял только один процесс.
+// TryLock возвращает токен захвата, Unlock снимает блокировку, только пока она принадлежит токену:
+// истекшую и захваченную заново блокировку прежний владелец не снимет
+type CacheLocker interface {
+	TryLock(key string, ttl time.Duration) (token string, ok bool)
+	Unlock(key string, token string)
+}
+
 // Executor интерфейс для выполнения запросов
 type Executor interface {
 	sqlx.Ext
@@ -236,4 +313,5 @@ type Executor interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 	SelectContext(ctx context.Context, dest any, query string, args ...any) error
 	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error)
 }
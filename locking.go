@@ -0,0 +1,154 @@
+package qb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// ErrStaleObject запись была изменена другим обновлением после чтения
+var ErrStaleObject = errors.New("qb: stale object")
+
+// StaleObjectError обновление с оптимистической блокировкой не затронуло ни одной строки
+type StaleObjectError struct {
+	Table   string
+	Column  string
+	Version any
+}
+
+func (e *StaleObjectError) Error() string {
+	return fmt.Sprintf("qb: stale object in %s: %s %v was changed by another update", e.Table, e.Column, e.Version)
+}
+
+func (e *StaleObjectError) Is(target error) bool {
+	return target == ErrStaleObject
+}
+
+// SetVersionColumn задает колонку версии таблицы для UpdateMap и структур без тега version.
+// В структурах колонка версии объявляется тегом db:"version,version"
+func (q *QueryBuilder) SetVersionColumn(table string, column string) {
+	if q.versionColumns == nil {
+		q.versionColumns = make(map[string]string)
+	}
+	q.versionColumns[normalizeTable(table)] = column
+}
+
+// DefaultVersionPrecision точность колонки версии со временем по умолчанию, как у TIMESTAMP в PostgreSQL
+const DefaultVersionPrecision = time.Microsecond
+
+// SetVersionPrecision задает точность, до которой усекается новое время в колонке версии.
+// Записанное в модель значение должно совпадать с сохраненным в базе, иначе следующее
+// обновление получит ErrStaleObject. Для DATETIME в MySQL без дробной части нужна time.Second
+func (q *QueryBuilder) SetVersionPrecision(precision time.Duration) {
+	q.versionPrecision = precision
+}
+
+// nextVersionTime возвращает новое время версии с точностью колонки
+func (q *QueryBuilder) nextVersionTime() time.Time {
+	precision := q.versionPrecision
+	if precision <= 0 {
+		precision = DefaultVersionPrecision
+	}
+	return time.Now().UTC().Truncate(precision)
+}
+
+// optimisticLock проверка и смена версии записи при UPDATE
+type optimisticLock struct {
+	column  string
+	current any
+	// next новое значение времени; nil — целочисленная версия увеличивается на 1
+	next  any
+	field reflect.Value
+}
+
+// structLock возвращает блокировку по колонке версии структуры
+func (qb *Builder) structLock(data any) *optimisticLock {
+	v := reflect.ValueOf(data)
+	m := mapStruct(v.Type())
+	f, ok := m.version()
+	if !ok {
+		if column := qb.queryBuilder.versionColumns[normalizeTable(qb.tableName)]; column != "" {
+			f, ok = m.byColumn[column]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	fv, ok := f.value(v)
+	if !ok {
+		return nil
+	}
+	lock := &optimisticLock{column: f.Column, current: fv.Interface()}
+	if fv.CanSet() {
+		lock.field = fv
+	}
+	if _, ok := lock.current.(time.Time); ok {
+		lock.next = qb.queryBuilder.nextVersionTime()
+	}
+	return lock
+}
+
+// mapLock возвращает блокировку, если data содержит ожидаемое значение колонки версии таблицы
+func (qb *Builder) mapLock(data map[string]any) (*optimisticLock, map[string]any) {
+	column := qb.queryBuilder.versionColumns[normalizeTable(qb.tableName)]
+	current, ok := data[column]
+	if column == "" || !ok {
+		return nil, data
+	}
+	rest := make(map[string]any, len(data))
+	for k, v := range data {
+		if k != column {
+			rest[k] = v
+		}
+	}
+	lock := &optimisticLock{column: column, current: current}
+	if _, ok := derefValue(current).(time.Time); ok {
+		lock.next = qb.queryBuilder.nextVersionTime()
+	}
+	return lock, rest
+}
+
+// apply добавляет смену версии в SET
+func (l *optimisticLock) apply(sets []string, args []any) ([]string, []any) {
+	if l.next == nil {
+		return append(sets, fmt.Sprintf("%s = %s + 1", l.column, l.column)), args
+	}
+	return append(sets, l.column+" = ?"), append(args, l.next)
+}
+
+// scope возвращает копию билдера с проверкой текущей версии для одного UPDATE.
+// Сам билдер не меняется, поэтому его можно использовать повторно
+func (l *optimisticLock) scope(qb *Builder) *Builder {
+	if l == nil {
+		return qb
+	}
+	scoped := *qb
+	scoped.conditions = slices.Clip(qb.conditions)
+	return scoped.Where(qb.QualifiedColumn(l.column)+" = ?", l.current)
+}
+
+// check возвращает StaleObjectError, если обновление не затронуло строк,
+// иначе записывает новую версию в поле модели
+func (l *optimisticLock) check(table string, rows int64, err error) error {
+	if l == nil || err != nil {
+		return err
+	}
+	if rows == 0 {
+		return &StaleObjectError{Table: table, Column: l.column, Version: l.current}
+	}
+	if !l.field.IsValid() {
+		return nil
+	}
+	if l.next != nil {
+		return setValue(l.field, l.next)
+	}
+	switch l.field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		l.field.SetInt(l.field.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		l.field.SetUint(l.field.Uint() + 1)
+	}
+	return nil
+}
@@ -7,40 +7,33 @@ import (
 
 func (q *QueryBuilder) Debug(msg string, start time.Time, query string, args ...any) {
 	if q.logger != nil {
-		q.logger.Debug(msg,
-			slog.String("query", query),
-			slog.Any("arg", args),
-			slog.String("mc", time.Since(start).String()),
-		)
+		q.logger.Debug(msg, q.logAttrs(start, query, args)...)
 	}
 }
 
 func (q *QueryBuilder) Info(msg string, start time.Time, query string, args ...any) {
 	if q.logger != nil {
-		q.logger.Info(msg,
-			slog.String("query", query),
-			slog.Any("arg", args),
-			slog.String("mc", time.Since(start).String()),
-		)
+		q.logger.Info(msg, q.logAttrs(start, query, args)...)
 	}
 }
 
 func (q *QueryBuilder) Warn(msg string, start time.Time, query string, args ...any) {
 	if q.logger != nil {
-		q.logger.Warn(msg,
-			slog.String("query", query),
-			slog.Any("arg", args),
-			slog.String("mc", time.Since(start).String()),
-		)
+		q.logger.Warn(msg, q.logAttrs(start, query, args)...)
 	}
 }
 
 func (q *QueryBuilder) Error(msg string, start time.Time, query string, args ...any) {
 	if q.logger != nil {
-		q.logger.Error(msg,
-			slog.String("query", query),
-			slog.Any("arg", args),
-			slog.String("mc", time.Since(start).String()),
-		)
+		q.logger.Error(msg, q.logAttrs(start, query, args)...)
 	}
 }
+
+// logAttrs собирает атрибуты записи лога запроса с учетом правил скрытия аргументов
+func (q *QueryBuilder) logAttrs(start time.Time, query string, args []any) []any {
+	attrs := []any{slog.String("query", query)}
+	if arg, ok := q.argsAttr(query, args); ok {
+		attrs = append(attrs, arg)
+	}
+	return append(attrs, slog.String("mc", time.Since(start).String()))
+}
@@ -0,0 +1,346 @@
+package qb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// structField колонка структуры с опциями тега db:
+//
+//	db:"name,omitempty"       пропускается при нулевом значении
+//	db:"name,readonly"        не записывается
+//	db:"name,insertonly"      записывается только при вставке
+//	db:"id,pk,autoincrement"  первичный ключ, генерируемый базой
+//	db:"version,version"      колонка версии для оптимистической блокировки
+//	db:",prefix=addr_"        вложенная структура, колонки с префиксом
+//
+// Колонка id без опций считается автоинкрементным первичным ключом
+type structField struct {
+	Column        string
+	Index         []int
+	Tagged        bool
+	OmitEmpty     bool
+	ReadOnly      bool
+	InsertOnly    bool
+	PK            bool
+	AutoIncrement bool
+	Version       bool
+}
+
+// structMap поля структуры в порядке объявления
+type structMap struct {
+	Fields   []*structField
+	byColumn map[string]*structField
+	// prefixed есть вложенные структуры с префиксом, их колонки читаются через маппер
+	prefixed bool
+}
+
+var structMaps sync.Map
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// mapStruct возвращает закешированное описание колонок типа структуры
+func mapStruct(t reflect.Type) *structMap {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if m, ok := structMaps.Load(t); ok {
+		return m.(*structMap)
+	}
+	m := &structMap{byColumn: make(map[string]*structField)}
+	m.walk(t, nil, "")
+	// Из одноименных колонок видна наименее вложенная, как поля встроенных структур в Go,
+	// при равной вложенности — объявленная первой
+	for _, f := range m.Fields {
+		if seen, ok := m.byColumn[f.Column]; !ok || len(f.Index) < len(seen.Index) {
+			m.byColumn[f.Column] = f
+		}
+	}
+	visible := m.Fields[:0]
+	explicitPK := false
+	for _, f := range m.Fields {
+		if m.byColumn[f.Column] == f {
+			visible = append(visible, f)
+			explicitPK = explicitPK || f.PK
+		}
+	}
+	m.Fields = visible
+	for _, f := range m.Fields {
+		if !explicitPK && f.Tagged && f.Column == "id" {
+			f.PK, f.AutoIncrement = true, true
+		}
+	}
+	actual, _ := structMaps.LoadOrStore(t, m)
+	return actual.(*structMap)
+}
+
+// walk обходит поля структуры, раскрывая встроенные и вложенные с префиксом структуры
+func (m *structMap) walk(t reflect.Type, index []int, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag, hasTag := f.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		fieldType := f.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		nestedPrefix, nested := tagOption(opts, "prefix")
+		composite := fieldType.Kind() == reflect.Struct && !isScalarStruct(fieldType)
+		if composite && f.Anonymous && name == "" && !nested {
+			// Поля встроенной структуры доступны по своим именам, как в Go
+			m.walk(fieldType, fieldIndex, prefix)
+			continue
+		}
+		if composite && nested {
+			m.prefixed = true
+			m.walk(fieldType, fieldIndex, prefix+nestedPrefix)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		field := &structField{
+			Column: prefix + name,
+			Index:  fieldIndex,
+			Tagged: hasTag,
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty":
+				field.OmitEmpty = true
+			case "readonly":
+				field.ReadOnly = true
+			case "insertonly":
+				field.InsertOnly = true
+			case "pk":
+				field.PK = true
+			case "autoincrement":
+				field.AutoIncrement = true
+			case "version":
+				field.Version = true
+			}
+		}
+		m.Fields = append(m.Fields, field)
+	}
+}
+
+// tagOption возвращает значение опции вида key=value
+func tagOption(opts string, key string) (string, bool) {
+	for _, opt := range strings.Split(opts, ",") {
+		if value, ok := strings.CutPrefix(opt, key+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// isScalarStruct проверяет, хранится ли структура в одной колонке: time.Time, sql.NullString и т.п.
+func isScalarStruct(t reflect.Type) bool {
+	return t.Implements(valuerType) || reflect.PointerTo(t).Implements(scannerType) || t.PkgPath() == "time"
+}
+
+// pk возвращает первичный ключ структуры
+func (m *structMap) pk() (*structField, bool) {
+	for _, f := range m.Fields {
+		if f.PK {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// version возвращает колонку версии структуры
+func (m *structMap) version() (*structField, bool) {
+	for _, f := range m.Fields {
+		if f.Version {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// value возвращает значение поля; false, если путь проходит через nil указатель
+func (f *structField) value(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	field, err := v.FieldByIndexErr(f.Index)
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	return field, true
+}
+
+// structColumn возвращает поле структуры по имени колонки
+func structColumn(v reflect.Value, column string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	f, ok := mapStruct(v.Type()).byColumn[column]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return f.value(v)
+}
+
+// writeColumns возвращает колонки и значения структуры для INSERT или UPDATE.
+// Явно указанные колонки (или имена полей Go) записываются как есть, неизвестные — ошибка
+func writeColumns(data any, insert bool, columns []string) ([]string, []any, error) {
+	v := reflect.ValueOf(data)
+	m := mapStruct(v.Type())
+	var names []string
+	var values []any
+
+	if len(columns) > 0 {
+		for _, column := range columns {
+			f, ok := m.byColumn[column]
+			if !ok {
+				return nil, nil, fmt.Errorf("qb: %s has no column %s", v.Type(), column)
+			}
+			var value any
+			if fv, ok := f.value(v); ok {
+				value = fv.Interface()
+			}
+			names = append(names, f.Column)
+			values = append(values, value)
+		}
+		return names, values, nil
+	}
+
+	for _, f := range m.Fields {
+		if !f.Tagged || f.ReadOnly || !insert && (f.PK || f.InsertOnly) {
+			continue
+		}
+		fv, ok := f.value(v)
+		if !ok {
+			continue
+		}
+		if fv.IsZero() && (f.OmitEmpty || insert && f.AutoIncrement) {
+			continue
+		}
+		names = append(names, f.Column)
+		values = append(values, fv.Interface())
+	}
+	return names, values, nil
+}
+
+// mappedStruct возвращает тип структуры dest, если ее колонки нужно читать через маппер:
+// sqlx не знает колонок вложенных структур с префиксом
+func mappedStruct(dest any) (reflect.Type, bool) {
+	t := reflect.TypeOf(dest)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || isScalarStruct(t) {
+		return nil, false
+	}
+	return t, mapStruct(t).prefixed
+}
+
+// getInto читает одну строку в dest
+func getInto(ctx context.Context, db Executor, dest any, query string, args ...any) error {
+	if _, ok := mappedStruct(dest); !ok {
+		return db.GetContext(ctx, dest, query, args...)
+	}
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if err := scanStruct(rows, columns, reflect.ValueOf(dest)); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// selectInto читает строки в срез структур или указателей на структуры dest
+func selectInto(ctx context.Context, db Executor, dest any, query string, args ...any) error {
+	t, ok := mappedStruct(dest)
+	if !ok {
+		return db.SelectContext(ctx, dest, query, args...)
+	}
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.IsNil() || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("qb: select needs a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+	ptrElem := slice.Type().Elem().Kind() == reflect.Ptr
+
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		item := reflect.New(t)
+		if err := scanStruct(rows, columns, item); err != nil {
+			return err
+		}
+		if ptrElem {
+			slice.Set(reflect.Append(slice, item))
+		} else {
+			slice.Set(reflect.Append(slice, item.Elem()))
+		}
+	}
+	return rows.Err()
+}
+
+// scanStruct сканирует текущую строку в структуру по колонкам маппера
+func scanStruct(rows *sqlx.Rows, columns []string, v reflect.Value) error {
+	v = reflect.Indirect(v)
+	m := mapStruct(v.Type())
+	targets := make([]any, len(columns))
+	for i, column := range columns {
+		f, ok := m.byColumn[column]
+		if !ok {
+			return fmt.Errorf("qb: missing destination name %s in %s", column, v.Type())
+		}
+		targets[i] = reflectx.FieldByIndexes(v, f.Index).Addr().Interface()
+	}
+	return rows.Scan(targets...)
+}
@@ -10,10 +10,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
-	"sync"
 	"time"
-
-	"github.com/jmoiron/sqlx"
 )
 
 func (qb *Builder) Context(ctx context.Context) *Builder {
@@ -40,7 +37,11 @@ func (qb *Builder) FindAsync(id any, dest any) (chan bool, chan error) {
 // Get получает все записи
 func (qb *Builder) Get(dest any) (bool, error) {
 	query, args := qb.buildSelectQuery()
-	return qb.execSelectContext(qb.ctx, dest, query, args...)
+	found, err := qb.execSelectContext(qb.ctx, dest, query, args...)
+	if err != nil || !found {
+		return found, err
+	}
+	return found, qb.afterFetch(dest)
 }
 func (qb *Builder) GetAsync(dest any) (chan bool, chan error) {
 	foundCh := make(chan bool, 1)
@@ -57,7 +58,11 @@ func (qb *Builder) GetAsync(dest any) (chan bool, chan error) {
 func (qb *Builder) First(dest any) (bool, error) {
 	qb.Limit(1)
 	query, args := qb.buildSelectQuery()
-	return qb.execGetContext(qb.ctx, dest, query, args...)
+	found, err := qb.execGetContext(qb.ctx, dest, query, args...)
+	if err != nil || !found {
+		return found, err
+	}
+	return found, qb.afterFetch(dest)
 }
 func (qb *Builder) FirstAsync(dest any) (chan bool, chan error) {
 	foundCh := make(chan bool, 1)
@@ -70,42 +75,66 @@ func (qb *Builder) FirstAsync(dest any) (chan bool, chan error) {
 	return foundCh, errorCh
 }
 
-func (qb *Builder) Create(data any, fields ...string) (any, error) {
+func (qb *Builder) Create(data any, fields ...string) (id any, err error) {
+	if qb.needsAuditTransaction() {
+		err = qb.inTransaction(func(b *Builder) error {
+			id, err = b.Create(data, fields...)
+			return err
+		})
+		return id, err
+	}
 	go qb.Trigger(BeforeCreate, data)
 	defer func() {
 		go qb.Trigger(AfterCreate, data)
 	}()
-	var insertFields, placeholders []string
-
-	if len(fields) > 0 {
-		// Используем только указанные поля
-		insertFields = fields
-		placeholders = make([]string, len(fields))
-		for i, field := range fields {
-			placeholders[i] = ":" + field
+	insertFields, args, err := writeColumns(data, true, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Колонка арендатора берется из контекста, а не из структуры
+	if tenantColumn, tenantID, ok := qb.tenantInsert(); ok {
+		columns, values := make([]string, 0, len(insertFields)+1), make([]any, 0, len(args)+1)
+		for i, column := range insertFields {
+			if column != tenantColumn {
+				columns = append(columns, column)
+				values = append(values, args[i])
+			}
 		}
-	} else {
-		// Используем все поля из структуры
-		insertFields, placeholders, _ = qb.getStructInfo(data)
+		insertFields, args = append(columns, tenantColumn), append(values, tenantID)
 	}
 
 	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		qb.tableName,
+		qb.table(),
 		strings.Join(insertFields, ", "),
-		strings.Join(placeholders, ", "))
+		strings.TrimSuffix(strings.Repeat("?, ", len(insertFields)), ", "))
+
+	pk := qb.primaryKey(data)
 
 	if qb.getDriverName() == "postgres" {
-		var id any
-		query += " RETURNING id"
-		err := qb.getExecutor().QueryRowx(query, data).Scan(&id)
-		return id, err
+		if _, err := qb.execGetContext(qb.ctx, &id, query+" RETURNING "+pk, args...); err != nil {
+			return id, err
+		}
+	} else {
+		result, err := qb.execResultContext(qb.ctx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return id, err
+		}
 	}
 
-	result, err := qb.getExecutor().NamedExecContext(qb.ctx, query, data)
-	if err != nil {
-		return 0, err
+	if qb.auditEnabled {
+		row := auditColumnsRow(insertFields, args)
+		if _, ok := row[pk]; !ok {
+			row[pk] = id
+		}
+		if err := qb.auditInserted(pk, row); err != nil {
+			return id, err
+		}
 	}
-	return result.LastInsertId()
+	return id, nil
 }
 func (qb *Builder) CreateAsync(data any, fields ...string) (chan any, chan error) {
 	idCh := make(chan any, 1)
@@ -119,7 +148,15 @@ func (qb *Builder) CreateAsync(data any, fields ...string) (chan any, chan error
 }
 
 // CreateMap создает новую запись из map и возвращает её id
-func (qb *Builder) CreateMap(data map[string]any) (any, error) {
+func (qb *Builder) CreateMap(data map[string]any) (id any, err error) {
+	if qb.needsAuditTransaction() {
+		err = qb.inTransaction(func(b *Builder) error {
+			id, err = b.CreateMap(data)
+			return err
+		})
+		return id, err
+	}
+	data = qb.tenantRecords(data)[0]
 	go qb.Trigger(BeforeCreate, data)
 	columns := make([]string, 0, len(data))
 	placeholders := make([]string, 0, len(data))
@@ -132,23 +169,39 @@ func (qb *Builder) CreateMap(data map[string]any) (any, error) {
 	}
 
 	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		qb.tableName,
+		qb.table(),
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "))
 
+	pk := qb.primaryKey(nil)
 	if qb.getDriverName() == "postgres" {
-		var id any
-		query = qb.rebindQuery(query + " RETURNING id")
-		err := qb.getExecutor().(sqlx.QueryerContext).QueryRowxContext(qb.ctx, query, values...).Scan(&id)
-		return id, err
+		if _, err := qb.execGetContext(qb.ctx, &id, query+" RETURNING "+pk, values...); err != nil {
+			return id, err
+		}
+	} else {
+		result, err := qb.execResultContext(qb.ctx, query, values...)
+		if err != nil {
+			return 0, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return id, err
+		}
 	}
+	go qb.Trigger(AfterCreate, data)
 
-	result, err := qb.getExecutor().ExecContext(qb.ctx, qb.rebindQuery(query), values...)
-	if err != nil {
-		return 0, err
+	if qb.auditEnabled {
+		row := make(map[string]any, len(data)+1)
+		for col, val := range data {
+			row[col] = val
+		}
+		if _, ok := row[pk]; !ok {
+			row[pk] = id
+		}
+		if err := qb.auditInserted(pk, normalizeAuditRow(row)); err != nil {
+			return id, err
+		}
 	}
-	go qb.Trigger(AfterCreate, data)
-	return result.LastInsertId()
+	return id, nil
 }
 func (qb *Builder) CreateMapAsync(data map[string]any) (chan any, chan error) {
 	idCh := make(chan any, 1)
@@ -166,6 +219,11 @@ func (qb *Builder) BatchInsert(records []map[string]any) error {
 	if len(records) == 0 {
 		return nil
 	}
+	// Аудиту нужны ключи вставленных строк, их восстанавливает BulkInsert
+	if qb.auditEnabled {
+		return qb.BulkInsert(records)
+	}
+	records = qb.tenantRecords(records...)
 
 	// Получаем все колонки из первой записи
 	columns := make([]string, 0)
@@ -188,7 +246,7 @@ func (qb *Builder) BatchInsert(records []map[string]any) error {
 
 	query := fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES %s",
-		qb.tableName,
+		qb.table(),
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "),
 	)
@@ -210,6 +268,12 @@ func (qb *Builder) BulkInsert(records []map[string]any) error {
 	if len(records) == 0 {
 		return nil
 	}
+	if qb.needsAuditTransaction() {
+		return qb.inTransaction(func(b *Builder) error {
+			return b.BulkInsert(records)
+		})
+	}
+	records = qb.tenantRecords(records...)
 
 	// Получаем все колонки из первой записи
 	columns := make([]string, 0)
@@ -230,27 +294,40 @@ func (qb *Builder) BulkInsert(records []map[string]any) error {
 		placeholders = append(placeholders, "("+strings.Join(placeholder, ", ")+")")
 	}
 
+	pk := qb.primaryKey(nil)
 	var query string
 	if qb.getDriverName() == "postgres" {
 		query = fmt.Sprintf(
-			"INSERT INTO %s (%s) VALUES %s RETURNING id",
-			qb.tableName,
+			"INSERT INTO %s (%s) VALUES %s RETURNING %s",
+			qb.table(),
 			strings.Join(columns, ", "),
 			strings.Join(placeholders, ", "),
+			pk,
 		)
 		var ids []any
-		err := qb.getExecutor().SelectContext(qb.ctx, &ids, qb.rebindQuery(query), values...)
-		return err
+		if _, err := qb.execSelectContext(qb.ctx, &ids, query, values...); err != nil {
+			return err
+		}
+		if !qb.auditEnabled {
+			return nil
+		}
+		rows := auditRecords(records)
+		for i := range rows {
+			if i < len(ids) {
+				rows[i][pk] = ids[i]
+			}
+		}
+		return qb.auditInserted(pk, rows...)
 	}
 
 	query = fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES %s",
-		qb.tableName,
+		qb.table(),
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "),
 	)
 
-	result, err := qb.getExecutor().(sqlx.ExtContext).ExecContext(qb.ctx, qb.rebindQuery(query), values...)
+	result, err := qb.execResultContext(qb.ctx, query, values...)
 	if err != nil {
 		return err
 	}
@@ -265,12 +342,31 @@ func (qb *Builder) BulkInsert(records []map[string]any) error {
 		return err
 	}
 
-	ids := make([]any, rowsAffected)
-	for i := range ids {
-		ids[i] = lastID
+	if !qb.auditEnabled {
+		return nil
 	}
+	ids := qb.insertedIDs(lastID, rowsAffected)
+	rows := auditRecords(records)
+	for i := range rows {
+		if _, ok := rows[i][pk]; !ok && i < len(ids) {
+			rows[i][pk] = ids[i]
+		}
+	}
+	return qb.auditInserted(pk, rows...)
+}
 
-	return nil
+// insertedIDs восстанавливает id строк многострочной вставки по LastInsertId:
+// MySQL возвращает id первой вставленной строки, SQLite — последней
+func (qb *Builder) insertedIDs(lastID int64, count int64) []any {
+	first := lastID
+	if qb.getDriverName() != "mysql" {
+		first = lastID - count + 1
+	}
+	ids := make([]any, count)
+	for i := range ids {
+		ids[i] = first + int64(i)
+	}
+	return ids
 }
 func (qb *Builder) BulkInsertAsync(records []map[string]any) chan error {
 	errorCh := make(chan error, 1)
@@ -288,8 +384,14 @@ func (qb *Builder) Update(data any, fields ...string) error {
 	defer func() {
 		go qb.Trigger(AfterUpdate, data)
 	}()
-	query, args := qb.buildUpdateQuery(data, fields)
-	return qb.execExecContext(qb.ctx, query, args...)
+	lock := qb.structLock(data)
+	b := lock.scope(qb)
+	query, args, err := b.buildUpdateQuery(data, fields, lock)
+	if err != nil {
+		return err
+	}
+	rows, err := b.auditedExecRows(AuditUpdate, qb.primaryKey(data), nil, query, args...)
+	return lock.check(qb.tableName, rows, err)
 }
 func (qb *Builder) UpdateAsync(data any, fields ...string) chan error {
 	ch := make(chan error, 1)
@@ -302,13 +404,20 @@ func (qb *Builder) UpdateAsync(data any, fields ...string) chan error {
 
 // UpdateMap обновляет записи используя map
 func (qb *Builder) UpdateMap(data map[string]any) error {
+	return qb.updateMap(data, AuditUpdate)
+}
+
+// updateMap обновляет записи используя map, фиксируя в аудите указанное действие
+func (qb *Builder) updateMap(data map[string]any, action string) error {
 	go qb.Trigger(BeforeUpdate, data)
 	defer func() {
 		go qb.Trigger(AfterUpdate, data)
 	}()
-	query, args := qb.buildUpdateMapQuery(data)
-	fmt.Println(query)
-	return qb.execExecContext(qb.ctx, query, args...)
+	lock, data := qb.mapLock(data)
+	b := lock.scope(qb)
+	query, args := b.buildUpdateMapQuery(data, lock)
+	rows, err := b.auditedExecRows(action, "", nil, query, args...)
+	return lock.check(qb.tableName, rows, err)
 }
 func (qb *Builder) UpdateMapAsync(data map[string]any) chan error {
 	ch := make(chan error, 1)
@@ -328,7 +437,7 @@ func (qb *Builder) BulkUpdate(records []map[string]any, keyColumn string) error
 	// Получаем все колонки из первой записи
 	columns := make([]string, 0)
 	for column := range records[0] {
-		if column != keyColumn {
+		if column != keyColumn && !qb.isTenantColumn(column) {
 			columns = append(columns, column)
 		}
 	}
@@ -357,18 +466,22 @@ func (qb *Builder) BulkUpdate(records []map[string]any, keyColumn string) error
 
 	query := fmt.Sprintf(
 		"UPDATE %s SET %s WHERE %s IN (%s)",
-		qb.tableName,
+		qb.table(),
 		strings.Join(cases, ", "),
 		keyColumn,
 		strings.Repeat("?,", len(records)-1)+"?",
 	)
 
 	// Объединяем все аргументы
-	args := make([]any, 0, len(valueArgs)+len(keyValues))
+	args := make([]any, 0, len(valueArgs)+len(keyValues)+1)
 	args = append(args, valueArgs...)
 	args = append(args, keyValues...)
+	if clause, tenantArgs, ok := qb.tenantCondition(qb.tableName, ""); ok {
+		query += " AND " + clause
+		args = append(args, tenantArgs...)
+	}
 
-	return qb.execExecContext(qb.ctx, query, args...)
+	return qb.auditedExec(AuditUpdate, keyColumn, keyValues, query, args...)
 }
 func (qb *Builder) BulkUpdateAsync(records []map[string]any, keyColumn string) chan error {
 	ch := make(chan error, 1)
@@ -415,15 +528,24 @@ func (qb *Builder) BatchUpdateAsync(records []map[string]any, keyColumn string,
 	return ch
 }
 
+// Delete удаляет записи, для таблиц с мягким удалением помечает их удаленными
 func (qb *Builder) Delete() error {
+	if qb.hasGlobalScope(SoftDeleteScope) && len(qb.conditions) > 0 {
+		return qb.SoftDelete()
+	}
+	return qb.forceDelete()
+}
+
+// forceDelete выполняет DELETE по условиям билдера
+func (qb *Builder) forceDelete() error {
 	if len(qb.conditions) == 0 {
 		return errors.New("delete without conditions is not allowed")
 	}
 
-	head := fmt.Sprintf("DELETE FROM %s", qb.tableName)
+	head := fmt.Sprintf("DELETE FROM %s", qb.table())
 	body, args := qb.buildBodyQuery()
 
-	return qb.execExecContext(qb.ctx, head+body, args...)
+	return qb.auditedExec(AuditDelete, "", nil, head+body, args...)
 }
 func (qb *Builder) DeleteAsync() chan error {
 	ch := make(chan error, 1)
@@ -483,56 +605,54 @@ func (qb *Builder) WhereIn(column string, values ...any) *Builder {
 
 // WhereGroup добавляет группу условий
 func (qb *Builder) WhereGroup(fn func(*Builder)) *Builder {
-	group := &Builder{}
+	group := qb.group()
 	fn(group)
 
-	var args []any
-	for _, cond := range group.conditions {
-		args = append(args, cond.args...)
-	}
-
 	qb.conditions = append(qb.conditions, Condition{
 		operator: "AND",
 		nested:   group.conditions,
-		args:     args, // Добавляем собранные аргументы
 	})
 	return qb
 }
 
 // OrWhereGroup добавляет группу условий через OR
 func (qb *Builder) OrWhereGroup(fn func(*Builder)) *Builder {
-	group := &Builder{}
+	group := qb.group()
 	fn(group)
-	var args []any
-	for _, cond := range group.conditions {
-		args = append(args, cond.args...)
-	}
 	qb.conditions = append(qb.conditions, Condition{
 		operator: "OR",
 		nested:   group.conditions,
-		args:     args,
 	})
 	return qb
 }
 
+// group возвращает билдер для группы условий той же таблицы
+func (qb *Builder) group() *Builder {
+	return &Builder{
+		tableName:    qb.tableName,
+		alias:        qb.alias,
+		joins:        qb.joins,
+		queryBuilder: qb.queryBuilder,
+		ctx:          qb.ctx,
+	}
+}
+
 // WhereExists добавляет условие EXISTS
 func (qb *Builder) WhereExists(subQuery *Builder) *Builder {
-	sql, args := subQuery.buildSelectQuery()
 	qb.conditions = append(qb.conditions, Condition{
 		operator: "AND",
-		clause:   fmt.Sprintf("EXISTS (%s)", sql),
-		args:     args,
+		clause:   "EXISTS (" + subQueryPlaceholder + ")",
+		subQuery: subQuery,
 	})
 	return qb
 }
 
 // WhereNotExists добавляет условие NOT EXISTS
 func (qb *Builder) WhereNotExists(subQuery *Builder) *Builder {
-	sql, args := subQuery.buildSelectQuery()
 	qb.conditions = append(qb.conditions, Condition{
 		operator: "AND",
-		clause:   fmt.Sprintf("NOT EXISTS (%s)", sql),
-		args:     args,
+		clause:   "NOT EXISTS (" + subQueryPlaceholder + ")",
+		subQuery: subQuery,
 	})
 	return qb
 }
@@ -575,74 +695,56 @@ func (qb *Builder) As(alias string) *Builder {
 
 // Increment увеличивает значение поля
 func (qb *Builder) Increment(column string, value any) error {
-	head := fmt.Sprintf("UPDATE %s SET %s = %s + ?", qb.tableName, column, column)
+	head := fmt.Sprintf("UPDATE %s SET %s = %s + ?", qb.table(), column, column)
 
 	body, args := qb.buildBodyQuery()
 
 	args = append([]any{value}, args...)
 
-	return qb.execExecContext(qb.ctx, head+body, args...)
+	return qb.auditedExec(AuditUpdate, "", nil, head+body, args...)
 }
 
 // Decrement уменьшает значение поля
 func (qb *Builder) Decrement(column string, value any) error {
-	head := fmt.Sprintf("UPDATE %s SET %s = %s - ?", qb.tableName, column, column)
+	head := fmt.Sprintf("UPDATE %s SET %s = %s - ?", qb.table(), column, column)
 
 	body, args := qb.buildBodyQuery()
 	args = append([]any{value}, args...)
 
-	return qb.execExecContext(qb.ctx, head+body, args...)
+	return qb.auditedExec(AuditUpdate, "", nil, head+body, args...)
 }
 
-// SubQuery создает подзапрос
+// SubQuery создает выборку из подзапроса с псевдонимом alias
 func (qb *Builder) SubQuery(alias string) *Builder {
-	sql, args := qb.buildSelectQuery()
-	return &Builder{
-		columns: []string{fmt.Sprintf("(%s) AS %s", sql, alias)},
-		db:      qb.db,
-		conditions: []Condition{{
-			args: args,
-		}},
-	}
+	return qb.derivedFrom(alias, "", qb)
 }
 
 // WhereSubQuery добавляет условие подзапросом
 func (qb *Builder) WhereSubQuery(column string, operator string, subQuery *Builder) *Builder {
-	sql, args := subQuery.buildSelectQuery()
 	qb.conditions = append(qb.conditions, Condition{
 		operator: "AND",
-		clause:   fmt.Sprintf("%s %s (%s)", column, operator, sql),
-		args:     args,
+		clause:   fmt.Sprintf("%s %s (%s)", column, operator, subQueryPlaceholder),
+		subQuery: subQuery,
 	})
 	return qb
 }
 
 // Union объединяет запросы через UNION
 func (qb *Builder) Union(other *Builder) *Builder {
-	sql1, args1 := qb.buildSelectQuery()
-	sql2, args2 := other.buildSelectQuery()
-
-	return &Builder{
-		db:      qb.db,
-		columns: []string{fmt.Sprintf("(%s) UNION (%s)", sql1, sql2)},
-		conditions: []Condition{{
-			args: append(args1, args2...),
-		}},
-	}
+	return qb.derivedFrom("union_query", "UNION", qb, other)
 }
 
 // UnionAll объединяет запросы через UNION ALL
 func (qb *Builder) UnionAll(other *Builder) *Builder {
-	sql1, args1 := qb.buildSelectQuery()
-	sql2, args2 := other.buildSelectQuery()
+	return qb.derivedFrom("union_query", "UNION ALL", qb, other)
+}
 
-	return &Builder{
-		db:      qb.db,
-		columns: []string{fmt.Sprintf("(%s) UNION ALL (%s)", sql1, sql2)},
-		conditions: []Condition{{
-			args: append(args1, args2...),
-		}},
-	}
+// derivedFrom создает билдер, выбирающий из подзапросов queries под псевдонимом alias
+func (qb *Builder) derivedFrom(alias string, operator string, queries ...*Builder) *Builder {
+	derived := qb.related("")
+	derived.alias = alias
+	derived.derived = &derivedTable{queries: queries, operator: operator}
+	return derived
 }
 
 // WhereNull добавляет проверку на NULL
@@ -696,6 +798,7 @@ func (qb *Builder) HavingRaw(sql string, args ...any) *Builder {
 // WithTransaction выполняет запрос в существующей транзакции
 func (qb *Builder) WithTransaction(tx *Transaction) *Builder {
 	qb.db = tx.Tx
+	qb.tx = tx
 	return qb
 }
 
@@ -779,7 +882,7 @@ func (qb *Builder) OrWhereRaw(sql string, args ...any) *Builder {
 
 // Pluck получает значения одной колонки
 func (qb *Builder) Pluck(column string, dest any) error {
-	head := fmt.Sprintf("SELECT %s FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT %s FROM %s", column, qb.table())
 
 	body, args := qb.buildBodyQuery()
 	_, err := qb.execSelect(dest, head+body, args...)
@@ -843,7 +946,7 @@ func (qb *Builder) Distinct(columns ...string) *Builder {
 // Value получает значение одного поля
 func (qb *Builder) Value(column string) (any, error) {
 	var result any
-	head := fmt.Sprintf("SELECT %s FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT %s FROM %s", column, qb.table())
 	qb.Limit(1)
 
 	body, args := qb.buildBodyQuery()
@@ -855,7 +958,7 @@ func (qb *Builder) Value(column string) (any, error) {
 // Values получает значения одного поля для всех записей
 func (qb *Builder) Values(column string) ([]any, error) {
 	var result []any
-	head := fmt.Sprintf("SELECT %s FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT %s FROM %s", column, qb.table())
 
 	body, args := qb.buildBodyQuery()
 
@@ -863,35 +966,6 @@ func (qb *Builder) Values(column string) ([]any, error) {
 	return result, err
 }
 
-// SoftDelete добавляет поддержку мягкого удаления
-type SoftDelete struct {
-	DeletedAt *time.Time `db:"deleted_at"`
-}
-
-// WithTrashed включает удаленные записи в выборку
-func (qb *Builder) WithTrashed() *Builder {
-	return qb
-}
-
-// OnlyTrashed выбирает только удаленные записи
-func (qb *Builder) OnlyTrashed() *Builder {
-	return qb.WhereNotNull("deleted_at")
-}
-
-// SoftDelete помечает записи как удаленные
-func (qb *Builder) SoftDelete() error {
-	return qb.UpdateMap(map[string]any{
-		"deleted_at": time.Now(),
-	})
-}
-
-// Restore восстанавливает удаленные записи
-func (qb *Builder) Restore() error {
-	return qb.UpdateMap(map[string]any{
-		"deleted_at": nil,
-	})
-}
-
 type JoinType string
 
 const (
@@ -1465,7 +1539,8 @@ func (qb *Builder) PaginateWithToken(token string, limit int, dest any) (*Pagina
 
 		// Создаем токен из последнего ID
 		lastItem := val.Index(limit - 1)
-		lastID := lastItem.FieldByName("ID").Int()
+		idField, _ := structColumn(lastItem, "id")
+		lastID := idField.Int()
 
 		tokenData, err := json.Marshal(lastID)
 		if err != nil {
@@ -1503,7 +1578,8 @@ func (qb *Builder) PaginateWithCursor(cursor string, limit int, dest any) (*Curs
 	if hasMore {
 		val.Set(val.Slice(0, limit))
 		lastItem := val.Index(limit - 1)
-		nextCursor := fmt.Sprint(lastItem.FieldByName("ID").Interface())
+		idField, _ := structColumn(lastItem, "id")
+		nextCursor := fmt.Sprint(idField.Interface())
 
 		return &CursorPagination{
 			Data:       dest,
@@ -1521,7 +1597,7 @@ func (qb *Builder) PaginateWithCursor(cursor string, limit int, dest any) (*Curs
 // Avg вычисляет среднее значение колонки
 func (qb *Builder) Avg(column string) (float64, error) {
 	var result float64
-	head := fmt.Sprintf("SELECT AVG(%s) FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT AVG(%s) FROM %s", column, qb.table())
 
 	body, args := qb.buildBodyQuery()
 	_, err := qb.execGetContext(qb.ctx, &result, head+body, args...)
@@ -1531,7 +1607,7 @@ func (qb *Builder) Avg(column string) (float64, error) {
 // Sum вычисляет сумму значений колонки
 func (qb *Builder) Sum(column string) (float64, error) {
 	var result float64
-	head := fmt.Sprintf("SELECT SUM(%s) FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT SUM(%s) FROM %s", column, qb.table())
 	body, args := qb.buildBodyQuery()
 	_, err := qb.execGetContext(qb.ctx, &result, head+body, args...)
 	return result, err
@@ -1540,7 +1616,7 @@ func (qb *Builder) Sum(column string) (float64, error) {
 // Min находит минимальное значение колонки
 func (qb *Builder) Min(column string) (float64, error) {
 	var result float64
-	head := fmt.Sprintf("SELECT MIN(%s) FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT MIN(%s) FROM %s", column, qb.table())
 	body, args := qb.buildBodyQuery()
 	_, err := qb.execGetContext(qb.ctx, &result, head+body, args...)
 	return result, err
@@ -1549,7 +1625,7 @@ func (qb *Builder) Min(column string) (float64, error) {
 // Max находит максимальное значение колонки
 func (qb *Builder) Max(column string) (float64, error) {
 	var result float64
-	head := fmt.Sprintf("SELECT MAX(%s) FROM %s", column, qb.tableName)
+	head := fmt.Sprintf("SELECT MAX(%s) FROM %s", column, qb.table())
 	body, args := qb.buildBodyQuery()
 	_, err := qb.execGetContext(qb.ctx, &result, head+body, args...)
 	return result, err
@@ -1558,10 +1634,9 @@ func (qb *Builder) Max(column string) (float64, error) {
 // Count возвращает количество записей
 func (qb *Builder) Count() (int64, error) {
 	var count int64
-	head := fmt.Sprintf("SELECT COUNT(*) FROM %s", qb.tableName)
+	head := fmt.Sprintf("SELECT COUNT(*) FROM %s", qb.table())
 
 	body, args := qb.buildBodyQuery()
-	fmt.Println(head+body, args)
 	_, err := qb.execGetContext(qb.ctx, &count, head+body, args...)
 	return count, err
 }
@@ -1575,242 +1650,6 @@ func (qb *Builder) Exists() (bool, error) {
 	return count > 0, nil
 }
 
-// AuditLog представляет запись аудита
-type AuditLog struct {
-	ID        int64     `db:"id"`
-	tableName string    `db:"table_name"`
-	RecordID  any       `db:"record_id"`
-	Action    string    `db:"action"`
-	OldData   []byte    `db:"old_data"`
-	NewData   []byte    `db:"new_data"`
-	UserID    any       `db:"user_id"`
-	CreatedAt time.Time `db:"created_at"`
-}
-
-// WithAudit включает аудит для запроса
-func (qb *Builder) WithAudit(userID any) *Builder {
-	qb.On(BeforeUpdate, func(data any) error {
-		var oldData []byte
-		var recordID any
-		var err error
-
-		for _, cond := range qb.conditions {
-			if cond.clause == "id = ?" {
-				recordID = cond.args[0]
-				break
-			}
-		}
-
-		switch v := data.(type) {
-		case map[string]any:
-			oldData, err = json.Marshal(v)
-		default:
-			oldData, err = json.Marshal(data)
-		}
-
-		if err != nil {
-			return err
-		}
-
-		// Создаем запись в таблице audits
-		_, err = qb.queryBuilder.From("audits").Create(&AuditLog{
-			tableName: qb.tableName,
-			RecordID:  recordID,
-			Action:    "update",
-			OldData:   oldData,
-			UserID:    userID,
-			CreatedAt: time.Now(),
-		})
-		return err
-	})
-
-	qb.On(AfterUpdate, func(data any) error {
-		var newData []byte
-		var recordID any
-		var err error
-
-		// Получаем ID из условий WHERE
-		for _, cond := range qb.conditions {
-			if cond.clause == "id = ?" {
-				recordID = any(cond.args[0].(int))
-				break
-			}
-		}
-
-		switch v := data.(type) {
-		case map[string]any:
-			newData, err = json.Marshal(v)
-		default:
-			newData, err = json.Marshal(data)
-		}
-
-		if err != nil {
-			return err
-		}
-
-		return qb.queryBuilder.From("audits").
-			Where("table_name = ?", qb.tableName).
-			Where("record_id = ?", recordID).
-			OrderBy("id", "DESC").
-			Limit(1).
-			UpdateMap(map[string]any{
-				"new_data": newData,
-			})
-	})
-
-	qb.On(AfterCreate, func(data any) error {
-		var newData []byte
-		var recordID any
-		var err error
-
-		switch v := data.(type) {
-		case map[string]any:
-			newData, err = json.Marshal(v)
-			if id, ok := v["id"]; ok {
-				recordID = id
-			}
-		default:
-			val := reflect.ValueOf(data)
-			if val.Kind() == reflect.Ptr {
-				val = val.Elem()
-			}
-			if val.Kind() == reflect.Struct {
-				recordID = val.FieldByName("ID").Int()
-			}
-			newData, err = json.Marshal(data)
-		}
-
-		if err != nil {
-			return err
-		}
-
-		// Создаем запись в таблице audits
-		_, err = qb.queryBuilder.From("audits").Create(&AuditLog{
-			tableName: qb.tableName,
-			RecordID:  recordID,
-			Action:    "create",
-			NewData:   newData,
-			UserID:    userID,
-			CreatedAt: time.Now(),
-		})
-		return err
-	})
-
-	return qb
-}
-
-// QueuedOperation представляет отложенную операцию
-type QueuedOperation struct {
-	ID        int64     `db:"id"`
-	Operation string    `db:"operation"`
-	Data      []byte    `db:"data"`
-	Status    string    `db:"status"`
-	RunAt     time.Time `db:"run_at"`
-}
-
-// Queue добавляет операцию в очередь
-func (qb *Builder) Queue(operation string, data any, runAt time.Time) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	_, err = qb.Create(&QueuedOperation{
-		Operation: operation,
-		Data:      jsonData,
-		Status:    "pending",
-		RunAt:     runAt,
-	})
-	return err
-}
-
-// ProcessQueue обрабатывает очередь
-func (qb *Builder) ProcessQueue(handler func(QueuedOperation) error) error {
-	var operations []QueuedOperation
-
-	_, err := qb.Where("status = ? AND run_at <= ?", "pending", time.Now()).
-		Get(&operations)
-	if err != nil {
-		return err
-	}
-
-	for _, op := range operations {
-		if err := handler(op); err != nil {
-			return err
-		}
-
-		err = qb.Where("id = ?", op.ID).
-			UpdateMap(map[string]any{
-				"status": "completed",
-			})
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// MetricsCollector собирает метрики выполнения запросов
-type MetricsCollector struct {
-	mu      sync.RWMutex
-	metrics map[string]*QueryMetrics
-}
-
-type QueryMetrics struct {
-	Count        int64
-	TotalTime    time.Duration
-	AverageTime  time.Duration
-	MaxTime      time.Duration
-	ErrorCount   int64
-	LastExecuted time.Time
-}
-
-func NewMetricsCollector() *MetricsCollector {
-	return &MetricsCollector{
-		metrics: make(map[string]*QueryMetrics),
-	}
-}
-
-func (mc *MetricsCollector) Track(query string, duration time.Duration, err error) {
-	mc.mu.Lock()
-	defer mc.mu.Unlock()
-
-	m, exists := mc.metrics[query]
-	if !exists {
-		m = &QueryMetrics{}
-		mc.metrics[query] = m
-	}
-
-	m.Count++
-	m.TotalTime += duration
-	m.AverageTime = m.TotalTime / time.Duration(m.Count)
-	if duration > m.MaxTime {
-		m.MaxTime = duration
-	}
-	if err != nil {
-		m.ErrorCount++
-	}
-	m.LastExecuted = time.Now()
-}
-
-// WithMetrics добавляет сбор метрик
-func (qb *Builder) WithMetrics(collector *MetricsCollector) *Builder {
-	qb.On(BeforeCreate, func(data any) error {
-		start := time.Now()
-		collector.Track("CREATE", time.Since(start), nil)
-		return nil
-	})
-
-	qb.On(BeforeUpdate, func(data any) error {
-		start := time.Now()
-		collector.Track("UPDATE", time.Since(start), nil)
-		return nil
-	})
-
-	return qb
-}
-
 // Remember включает кеширование для запроса
 func (qb *Builder) Remember(key string, duration time.Duration) *Builder {
 	qb.cacheKey = key
@@ -1818,34 +1657,14 @@ func (qb *Builder) Remember(key string, duration time.Duration) *Builder {
 	return qb
 }
 
-// GetCached получает данные с учетом кеша
+// GetCached получает данные с учетом кеша по ключу из Remember.
+// Без Remember работает как Get, в том числе с кешем из Cache
 func (qb *Builder) GetCached(dest any) (bool, error) {
-	// Проверяем наличие ключа кеша
-	if qb.cacheKey != "" {
-		// Пытаемся получить из кеша
-		if cached, ok := qb.queryBuilder.cache.Get(qb.cacheKey); ok {
-			// Копируем закешированные данные
-			if data, ok := cached.([]byte); ok {
-				return true, json.Unmarshal(data, dest)
-			}
-		}
-	}
-
-	// Если в кеше нет, получаем из БД
-	found, err := qb.Get(dest)
-	if err != nil {
-		return false, err
-	}
-
-	// Сохраняем результат в кеш
-	if found && qb.cacheKey != "" {
-		// Сериализуем данные перед сохранением
-		data, err := json.Marshal(dest)
-		if err != nil {
-			return false, err
-		}
-		qb.queryBuilder.cache.Set(qb.cacheKey, data, qb.cacheDuration)
+	if qb.cacheKey == "" {
+		return qb.Get(dest)
 	}
-
-	return found, nil
+	query, args := qb.buildSelectQuery()
+	return qb.fetchCached(qb.ctx, qb.cacheKey, dest, func(ctx context.Context, dest any) (bool, error) {
+		return qb.execSelectContext(ctx, dest, query, args...)
+	})
 }
@@ -0,0 +1,326 @@
+package qb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets границы гистограммы задержек в секундах
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// QueryEvent описывает выполнение одного запроса
+type QueryEvent struct {
+	Operation string
+	Table     string
+	SQL       string
+	Args      []any
+	Duration  time.Duration
+	Rows      int64
+	Err       error
+}
+
+// MetricsCollector собирает метрики выполнения запросов
+type MetricsCollector struct {
+	mu      sync.RWMutex
+	buckets []float64
+	metrics map[string]*QueryMetrics
+
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+}
+
+// QueryMetrics метрики одного отпечатка запроса
+type QueryMetrics struct {
+	Fingerprint string
+	Operation   string
+	Table       string
+	Count       int64
+	TotalTime   time.Duration
+	AverageTime time.Duration
+	MinTime     time.Duration
+	MaxTime     time.Duration
+	Rows        int64
+	ErrorCount  int64
+	// Errors число ошибок по классам, см. ErrorClass
+	Errors map[string]int64
+	// Buckets число запросов в каждом интервале гистограммы,
+	// последний элемент — запросы дольше последней границы
+	Buckets      []int64
+	LastExecuted time.Time
+}
+
+func NewMetricsCollector() *MetricsCollector {
+	return NewMetricsCollectorWithBuckets(DefaultLatencyBuckets)
+}
+
+// NewMetricsCollectorWithBuckets создает сборщик с собственными границами гистограммы в секундах
+func NewMetricsCollectorWithBuckets(buckets []float64) *MetricsCollector {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &MetricsCollector{
+		buckets: b,
+		metrics: make(map[string]*QueryMetrics),
+	}
+}
+
+// Track фиксирует выполнение запроса
+func (mc *MetricsCollector) Track(query string, duration time.Duration, err error) {
+	mc.Record(QueryEvent{
+		Operation: queryOperation(query),
+		Table:     queryTable(query),
+		SQL:       query,
+		Duration:  duration,
+		Err:       err,
+	})
+}
+
+// Record фиксирует событие выполнения запроса
+func (mc *MetricsCollector) Record(event QueryEvent) {
+	fingerprint := Fingerprint(event.SQL)
+	key := event.Operation + "|" + event.Table + "|" + fingerprint
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	m, exists := mc.metrics[key]
+	if !exists {
+		m = &QueryMetrics{
+			Fingerprint: fingerprint,
+			Operation:   event.Operation,
+			Table:       event.Table,
+			MinTime:     event.Duration,
+			Buckets:     make([]int64, len(mc.buckets)+1),
+		}
+		mc.metrics[key] = m
+	}
+
+	m.Count++
+	m.TotalTime += event.Duration
+	m.AverageTime = m.TotalTime / time.Duration(m.Count)
+	if event.Duration > m.MaxTime {
+		m.MaxTime = event.Duration
+	}
+	if event.Duration < m.MinTime {
+		m.MinTime = event.Duration
+	}
+	m.Rows += event.Rows
+	m.Buckets[sort.SearchFloat64s(mc.buckets, event.Duration.Seconds())]++
+	if event.Err != nil {
+		m.ErrorCount++
+		if m.Errors == nil {
+			m.Errors = make(map[string]int64)
+		}
+		m.Errors[ErrorClass(event.Err)]++
+	}
+	m.LastExecuted = time.Now()
+}
+
+// RecordCache фиксирует попадание или промах кеша
+func (mc *MetricsCollector) RecordCache(hit bool) {
+	if hit {
+		mc.cacheHits.Add(1)
+	} else {
+		mc.cacheMisses.Add(1)
+	}
+}
+
+// CacheStats возвращает число попаданий и промахов кеша
+func (mc *MetricsCollector) CacheStats() (hits int64, misses int64) {
+	return mc.cacheHits.Load(), mc.cacheMisses.Load()
+}
+
+// Buckets возвращает границы гистограммы задержек в секундах
+func (mc *MetricsCollector) Buckets() []float64 {
+	return append([]float64(nil), mc.buckets...)
+}
+
+// Snapshot возвращает копию накопленных метрик, отсортированную по суммарному времени
+func (mc *MetricsCollector) Snapshot() []QueryMetrics {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	result := make([]QueryMetrics, 0, len(mc.metrics))
+	for _, m := range mc.metrics {
+		c := *m
+		c.Buckets = append([]int64(nil), m.Buckets...)
+		if m.Errors != nil {
+			c.Errors = make(map[string]int64, len(m.Errors))
+			for class, n := range m.Errors {
+				c.Errors[class] = n
+			}
+		}
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalTime != result[j].TotalTime {
+			return result[i].TotalTime > result[j].TotalTime
+		}
+		return result[i].Fingerprint < result[j].Fingerprint
+	})
+	return result
+}
+
+// Reset очищает накопленные метрики
+func (mc *MetricsCollector) Reset() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.metrics = make(map[string]*QueryMetrics)
+	mc.cacheHits.Store(0)
+	mc.cacheMisses.Store(0)
+}
+
+// SetMetrics подключает сборщик метрик ко всем запросам
+func (q *QueryBuilder) SetMetrics(collector *MetricsCollector) {
+	q.metrics = collector
+}
+
+// GetMetrics возвращает подключенный сборщик метрик
+func (q *QueryBuilder) GetMetrics() *MetricsCollector {
+	return q.metrics
+}
+
+// recordCache передает попадание или промах кеша в метрики
+func (qb *Builder) recordCache(hit bool) {
+	if qb.queryBuilder.metrics != nil {
+		qb.queryBuilder.metrics.RecordCache(hit)
+	}
+	if qb.metrics != nil && qb.metrics != qb.queryBuilder.metrics {
+		qb.metrics.RecordCache(hit)
+	}
+}
+
+// WithMetrics добавляет сбор метрик запросов билдера в отдельный сборщик
+func (qb *Builder) WithMetrics(collector *MetricsCollector) *Builder {
+	qb.metrics = collector
+	return qb
+}
+
+var (
+	fingerprintInList = regexp.MustCompile(`\(\s?\?(?:\s?,\s?\?)*\s?\)`)
+	fingerprintRepeat = regexp.MustCompile(`\(\?\+\)(?:\s?,\s?\(\?\+\))+`)
+	queryTableRe      = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+([\w."` + "`" + `]+)`)
+)
+
+// Fingerprint нормализует запрос: литералы и плейсхолдеры заменяются на ?,
+// списки значений сворачиваются в (?+), пробелы схлопываются
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case c == '\'':
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			c = '?'
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			for i+1 < len(query) && isDigit(query[i+1]) {
+				i++
+			}
+			c = '?'
+		case isDigit(c) && (i == 0 || !isIdentChar(query[i-1])):
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			c = '?'
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteByte(c)
+	}
+
+	s := fingerprintInList.ReplaceAllString(b.String(), "(?+)")
+	return fingerprintRepeat.ReplaceAllString(s, "(?+)")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// queryOperation определяет тип операции по первому слову запроса
+func queryOperation(query string) string {
+	query = strings.TrimLeft(query, " \t\n\r(")
+	end := strings.IndexAny(query, " \t\n\r(")
+	if end < 0 {
+		end = len(query)
+	}
+	switch op := strings.ToLower(query[:end]); op {
+	case "select", "with":
+		return "select"
+	case "insert", "update", "delete", "replace":
+		return op
+	case "":
+		return "other"
+	default:
+		return op
+	}
+}
+
+// queryTable определяет основную таблицу запроса
+func queryTable(query string) string {
+	m := queryTableRe.FindStringSubmatch(query)
+	if m == nil {
+		return ""
+	}
+	return strings.Trim(m[1], "`\"")
+}
+
+// ErrorClass возвращает класс ошибки запроса:
+// timeout, canceled, connection, tx_done, constraint, syntax, deadlock или other
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return "connection"
+	case errors.Is(err, sql.ErrTxDone):
+		return "tx_done"
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "deadlock"):
+		return "deadlock"
+	case strings.Contains(msg, "duplicate"), strings.Contains(msg, "unique"),
+		strings.Contains(msg, "foreign key"), strings.Contains(msg, "constraint"):
+		return "constraint"
+	case strings.Contains(msg, "syntax"):
+		return "syntax"
+	case strings.Contains(msg, "timeout"):
+		return "timeout"
+	case strings.Contains(msg, "connection"), strings.Contains(msg, "broken pipe"):
+		return "connection"
+	default:
+		return "other"
+	}
+}
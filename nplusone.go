@@ -0,0 +1,167 @@
+package qb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// NPlusOneMode реакция детектора N+1 на обнаруженную проблему
+type NPlusOneMode int
+
+const (
+	// NPlusOneModeWarn пишет предупреждение в лог
+	NPlusOneModeWarn NPlusOneMode = iota
+	// NPlusOneModeError дополнительно возвращает *NPlusOneError вместо выполнения запроса
+	NPlusOneModeError
+)
+
+// NPlusOneOptions настройки детектора N+1 запросов
+type NPlusOneOptions struct {
+	// Threshold число выполнений одного отпечатка с разными аргументами,
+	// после превышения которого срабатывает детектор
+	Threshold int
+	// Mode реакция на обнаружение, по умолчанию NPlusOneModeWarn
+	Mode NPlusOneMode
+	// OnDetect вызывается один раз на отпечаток в пределах области,
+	// в тестах сюда можно передать функцию с t.Fatal
+	OnDetect func(report NPlusOneReport)
+}
+
+// NPlusOneReport описание обнаруженного N+1
+type NPlusOneReport struct {
+	Fingerprint string
+	Table       string
+	// Count число выполнений с разными аргументами
+	Count int
+	// FirstStack стек вызовов первого выполнения запроса
+	FirstStack string
+}
+
+// NPlusOneError ошибка, возвращаемая запросом в режиме NPlusOneModeError
+type NPlusOneError struct {
+	Report NPlusOneReport
+}
+
+func (e *NPlusOneError) Error() string {
+	return fmt.Sprintf("qb: N+1 query detected: %q executed %d times with different args, first at:\n%s",
+		e.Report.Fingerprint, e.Report.Count, e.Report.FirstStack)
+}
+
+// nPlusOneDetector включенный детектор N+1
+type nPlusOneDetector struct {
+	opts NPlusOneOptions
+}
+
+type queryScopeKey struct{}
+
+// queryScope область наблюдения детектора: HTTP-запрос, задача и т.п.
+type queryScope struct {
+	mu      sync.Mutex
+	queries map[string]*scopedQuery
+}
+
+type scopedQuery struct {
+	args     map[string]struct{}
+	stack    string
+	reported bool
+}
+
+// WithQueryScope начинает новую область наблюдения детектора N+1.
+// Запросы с контекстом без области не проверяются.
+func WithQueryScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryScopeKey{}, &queryScope{queries: make(map[string]*scopedQuery)})
+}
+
+// SetNPlusOneDetector включает детектор N+1 запросов для областей, созданных WithQueryScope.
+// Нулевой Threshold отключает детектор.
+func (q *QueryBuilder) SetNPlusOneDetector(opts NPlusOneOptions) {
+	if opts.Threshold <= 0 {
+		q.nPlusOne = nil
+		return
+	}
+	q.nPlusOne = &nPlusOneDetector{opts: opts}
+}
+
+// detectNPlusOne учитывает запрос в области контекста и сообщает о повторениях
+func (q *QueryBuilder) detectNPlusOne(ctx context.Context, event QueryEvent) error {
+	d := q.nPlusOne
+	if d == nil || event.Operation != "select" {
+		return nil
+	}
+	scope, ok := ctx.Value(queryScopeKey{}).(*queryScope)
+	if !ok {
+		return nil
+	}
+
+	fingerprint := Fingerprint(event.SQL)
+	args := event.Args
+	if len(args) == 1 {
+		if nested, ok := args[0].([]any); ok {
+			args = nested
+		}
+	}
+	argsKey := fmt.Sprintf("%#v", args)
+
+	scope.mu.Lock()
+	sq, exists := scope.queries[fingerprint]
+	if !exists {
+		sq = &scopedQuery{args: make(map[string]struct{}), stack: callerStack()}
+		scope.queries[fingerprint] = sq
+	}
+	sq.args[argsKey] = struct{}{}
+	count := len(sq.args)
+	if count <= d.opts.Threshold {
+		scope.mu.Unlock()
+		return nil
+	}
+	report := !sq.reported
+	sq.reported = true
+	stack := sq.stack
+	scope.mu.Unlock()
+
+	r := NPlusOneReport{
+		Fingerprint: fingerprint,
+		Table:       event.Table,
+		Count:       count,
+		FirstStack:  stack,
+	}
+	if report {
+		if q.logger != nil {
+			q.logger.Warn("N+1 query detected",
+				slog.String("fingerprint", fingerprint),
+				slog.String("table", event.Table),
+				slog.Int("count", count),
+				slog.String("first_stack", stack),
+			)
+		}
+		if d.opts.OnDetect != nil {
+			d.opts.OnDetect(r)
+		}
+	}
+	if d.opts.Mode == NPlusOneModeError {
+		return &NPlusOneError{Report: r}
+	}
+	return nil
+}
+
+// callerStack возвращает стек вызовов за пределами пакета
+func callerStack() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePath+".") && !strings.HasPrefix(frame.Function, "runtime.") {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,277 @@
+package qb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultOutboxTable таблица outbox по умолчанию
+const DefaultOutboxTable = "outbox"
+
+// OutboxMessage сообщение, сохраненное в outbox.
+//
+// Ожидаемая схема таблицы:
+//
+//	id, topic, message_key, payload, headers, attempts, last_error NULL,
+//	locked_until NULL, locked_by NULL, delivered_at NULL, created_at
+type OutboxMessage struct {
+	ID        int64
+	Topic     string
+	Key       string
+	Payload   []byte
+	Headers   map[string]string
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// outboxRow строка таблицы outbox
+type outboxRow struct {
+	ID          int64      `db:"id"`
+	Topic       string     `db:"topic"`
+	Key         string     `db:"message_key"`
+	Payload     []byte     `db:"payload"`
+	Headers     []byte     `db:"headers"`
+	Attempts    int        `db:"attempts"`
+	LastError   *string    `db:"last_error"`
+	LockedUntil *time.Time `db:"locked_until"`
+	LockedBy    *string    `db:"locked_by"`
+	DeliveredAt *time.Time `db:"delivered_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
+
+// Publisher доставляет сообщения outbox во внешнюю систему
+type Publisher interface {
+	Publish(ctx context.Context, msg OutboxMessage) error
+}
+
+// SetOutboxTable задает таблицу outbox
+func (q *QueryBuilder) SetOutboxTable(table string) {
+	q.outboxTable = table
+}
+
+func (q *QueryBuilder) getOutboxTable() string {
+	if q.outboxTable == "" {
+		return DefaultOutboxTable
+	}
+	return q.outboxTable
+}
+
+// Outbox сохраняет сообщение в outbox в рамках транзакции.
+// Порядок доставки сохраняется в пределах topic.
+func (t *Transaction) Outbox(topic string, payload any, headers map[string]string) error {
+	return t.OutboxWithKey(topic, topic, payload, headers)
+}
+
+// OutboxWithKey сохраняет сообщение в outbox в рамках транзакции.
+// Порядок доставки сохраняется в пределах key.
+func (t *Transaction) OutboxWithKey(topic string, key string, payload any, headers map[string]string) error {
+	data, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	var headersData []byte
+	if len(headers) > 0 {
+		var err error
+		if headersData, err = json.Marshal(headers); err != nil {
+			return err
+		}
+	}
+
+	_, err := t.From(t.QueryBuilder.getOutboxTable()).CreateMap(map[string]any{
+		"topic":       topic,
+		"message_key": key,
+		"payload":     data,
+		"headers":     headersData,
+		"attempts":    0,
+		"created_at":  time.Now().UTC(),
+	})
+	return err
+}
+
+// OutboxRelayOptions настройки доставки сообщений outbox
+type OutboxRelayOptions struct {
+	// BatchSize максимальное число сообщений за один проход
+	BatchSize int
+	// PollInterval интервал опроса пустого outbox
+	PollInterval time.Duration
+	// LockTimeout время, после которого захваченное сообщение снова доступно
+	LockTimeout time.Duration
+	// Backoff задержка перед повторной доставкой
+	Backoff func(attempt int) time.Duration
+	// DeleteDelivered удаляет сообщения сразу после доставки
+	DeleteDelivered bool
+	// Retention срок хранения доставленных сообщений, 0 — без очистки
+	Retention time.Duration
+}
+
+// OutboxRelay доставляет сообщения outbox с семантикой at-least-once
+type OutboxRelay struct {
+	queryBuilder *QueryBuilder
+	publisher    Publisher
+	opts         OutboxRelayOptions
+}
+
+// OutboxRelay создает доставщик сообщений outbox
+func (q *QueryBuilder) OutboxRelay(publisher Publisher, opts OutboxRelayOptions) *OutboxRelay {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = time.Minute
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(time.Second, 5*time.Minute)
+	}
+	return &OutboxRelay{queryBuilder: q, publisher: publisher, opts: opts}
+}
+
+// builder создает билдер таблицы outbox. Захват перечитывает только что
+// обновленные строки, поэтому чтение идет с основной базы
+func (r *OutboxRelay) builder(ctx context.Context) *Builder {
+	return &Builder{
+		tableName:    r.queryBuilder.getOutboxTable(),
+		db:           r.queryBuilder.db,
+		queryBuilder: r.queryBuilder,
+		ctx:          ctx,
+		usePrimary:   true,
+	}
+}
+
+// claim захватывает первые недоставленные сообщения каждого ключа.
+// Следующее сообщение ключа становится доступным только после доставки предыдущего.
+func (r *OutboxRelay) claim(ctx context.Context) ([]outboxRow, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	table := r.queryBuilder.getOutboxTable()
+	b := r.builder(ctx)
+
+	// Доступность проверяется внутри выборки голов: иначе головы в ожидании повтора
+	// занимают весь BatchSize и сообщения других ключей не захватываются
+	query := fmt.Sprintf(
+		"UPDATE %[1]s SET locked_until = ?, locked_by = ? WHERE id IN (SELECT id FROM ("+
+			"SELECT o.id FROM (SELECT MIN(id) AS id FROM %[1]s WHERE delivered_at IS NULL GROUP BY message_key) h "+
+			"JOIN %[1]s o ON o.id = h.id WHERE o.locked_until IS NULL OR o.locked_until <= ? ORDER BY o.id LIMIT %[2]d) heads) "+
+			"AND (locked_until IS NULL OR locked_until <= ?)",
+		table, r.opts.BatchSize)
+	if err := b.execExecContext(ctx, query, now.Add(r.opts.LockTimeout), token, now, now); err != nil {
+		return nil, err
+	}
+
+	var rows []outboxRow
+	query = fmt.Sprintf("SELECT * FROM %s WHERE locked_by = ? AND delivered_at IS NULL ORDER BY id", table)
+	if _, err := b.execSelectContext(ctx, &rows, query, token); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// RelayOnce выполняет один проход доставки и возвращает число доставленных сообщений
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	rows, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, row := range rows {
+		msg := OutboxMessage{
+			ID:        row.ID,
+			Topic:     row.Topic,
+			Key:       row.Key,
+			Payload:   row.Payload,
+			Attempts:  row.Attempts,
+			CreatedAt: row.CreatedAt,
+		}
+		if len(row.Headers) > 0 {
+			if err := json.Unmarshal(row.Headers, &msg.Headers); err != nil {
+				return delivered, err
+			}
+		}
+
+		start := time.Now()
+		pubErr := r.publisher.Publish(ctx, msg)
+		if mc := r.queryBuilder.metrics; mc != nil {
+			mc.Record(QueryEvent{
+				Operation: "publish",
+				Table:     r.queryBuilder.getOutboxTable(),
+				Duration:  time.Since(start),
+				Err:       pubErr,
+			})
+		}
+
+		b := r.builder(context.WithoutCancel(ctx)).Where("id = ?", row.ID).Where("locked_by = ?", *row.LockedBy)
+		if pubErr != nil {
+			r.queryBuilder.Warn(fmt.Sprintf("outbox message %d to %s publish failed: %s", row.ID, row.Topic, pubErr), start, "")
+			err = b.UpdateMap(map[string]any{
+				"attempts":     row.Attempts + 1,
+				"last_error":   pubErr.Error(),
+				"locked_until": time.Now().UTC().Add(r.opts.Backoff(row.Attempts + 1)),
+				"locked_by":    nil,
+			})
+		} else if r.opts.DeleteDelivered {
+			err = b.Delete()
+		} else {
+			err = b.UpdateMap(map[string]any{
+				"delivered_at": time.Now().UTC(),
+				"locked_until": nil,
+				"locked_by":    nil,
+			})
+		}
+		if err != nil {
+			return delivered, err
+		}
+		if pubErr == nil {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// Cleanup удаляет доставленные сообщения старше olderThan
+func (r *OutboxRelay) Cleanup(ctx context.Context, olderThan time.Duration) error {
+	return r.builder(ctx).
+		WhereNotNull("delivered_at").
+		Where("delivered_at < ?", time.Now().UTC().Add(-olderThan)).
+		Delete()
+}
+
+// Run доставляет сообщения до отмены контекста
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	lastCleanup := time.Now()
+	for {
+		delivered, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.queryBuilder.Warn("outbox relay failed: "+err.Error(), time.Now(), "")
+		}
+
+		if r.opts.Retention > 0 && time.Since(lastCleanup) >= r.opts.Retention {
+			if err := r.Cleanup(ctx, r.opts.Retention); err != nil && ctx.Err() == nil {
+				r.queryBuilder.Warn("outbox cleanup failed: "+err.Error(), time.Now(), "")
+			}
+			lastCleanup = time.Now()
+		}
+
+		if delivered > 0 && err == nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+		select {
+		case <-time.After(r.opts.PollInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
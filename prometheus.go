@@ -0,0 +1,290 @@
+package qb
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusExporter выводит метрики запросов, кеша и пула соединений
+// в текстовом формате Prometheus
+type PrometheusExporter struct {
+	queryBuilder *QueryBuilder
+	namespace    string
+	fingerprints int
+}
+
+// PrometheusExporter создает экспортер метрик с префиксом "qb"
+func (q *QueryBuilder) PrometheusExporter() *PrometheusExporter {
+	return &PrometheusExporter{queryBuilder: q, namespace: "qb"}
+}
+
+// Namespace задает префикс имен метрик
+func (e *PrometheusExporter) Namespace(namespace string) *PrometheusExporter {
+	e.namespace = namespace
+	return e
+}
+
+// Fingerprints добавляет метку fingerprint для limit запросов с наибольшим суммарным временем,
+// остальные запросы выводятся с fingerprint="other". По умолчанию метка не выводится,
+// чтобы число рядов не росло вместе с числом разных запросов
+func (e *PrometheusExporter) Fingerprints(limit int) *PrometheusExporter {
+	e.fingerprints = limit
+	return e
+}
+
+// ServeHTTP отдает метрики для сбора Prometheus
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := e.WriteTo(w); err != nil {
+		e.queryBuilder.Warn("prometheus export failed: "+err.Error(), time.Now(), "")
+	}
+}
+
+// WriteTo записывает метрики в текстовом формате Prometheus
+func (e *PrometheusExporter) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	if mc := e.queryBuilder.metrics; mc != nil {
+		e.writeQueries(cw, mc)
+		e.writeCache(cw, mc)
+	}
+	if pools := e.pools(); len(pools) > 0 {
+		e.writeDBStats(cw, pools)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// querySeries метрики запросов с одинаковым набором меток
+type querySeries struct {
+	table, operation, fingerprint string
+	count, rows                   int64
+	total                         time.Duration
+	buckets                       []int64
+}
+
+// querySeries объединяет метрики отпечатков по таблице и операции,
+// оставляя метку fingerprint только для первых e.fingerprints запросов
+func (e *PrometheusExporter) querySeries(snapshot []QueryMetrics) []*querySeries {
+	byLabels := make(map[[3]string]*querySeries)
+	var series []*querySeries
+	// Snapshot отсортирован по суммарному времени, поэтому метку сохраняют самые тяжелые запросы
+	for i, m := range snapshot {
+		fingerprint := ""
+		if e.fingerprints > 0 {
+			fingerprint = "other"
+			if i < e.fingerprints {
+				fingerprint = m.Fingerprint
+			}
+		}
+		key := [3]string{m.Table, m.Operation, fingerprint}
+		s, ok := byLabels[key]
+		if !ok {
+			s = &querySeries{table: m.Table, operation: m.Operation, fingerprint: fingerprint, buckets: make([]int64, len(m.Buckets))}
+			byLabels[key] = s
+			series = append(series, s)
+		}
+		s.count += m.Count
+		s.rows += m.Rows
+		s.total += m.TotalTime
+		for b, n := range m.Buckets {
+			s.buckets[b] += n
+		}
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].table != series[j].table {
+			return series[i].table < series[j].table
+		}
+		if series[i].operation != series[j].operation {
+			return series[i].operation < series[j].operation
+		}
+		return series[i].fingerprint < series[j].fingerprint
+	})
+	return series
+}
+
+func (e *PrometheusExporter) writeQueries(w *countingWriter, mc *MetricsCollector) {
+	snapshot := mc.Snapshot()
+	series := e.querySeries(snapshot)
+	buckets := mc.Buckets()
+
+	name := e.name("queries_total")
+	w.header(name, "counter", "Number of executed queries.")
+	for _, s := range series {
+		w.sample(name, s.labels(), strconv.FormatInt(s.count, 10))
+	}
+
+	name = e.name("query_rows_total")
+	w.header(name, "counter", "Rows returned or affected by queries.")
+	for _, s := range series {
+		w.sample(name, s.labels(), strconv.FormatInt(s.rows, 10))
+	}
+
+	name = e.name("query_duration_seconds")
+	w.header(name, "histogram", "Query latency in seconds.")
+	for _, s := range series {
+		labels := s.labels()
+		var cumulative int64
+		for i, bound := range buckets {
+			cumulative += s.buckets[i]
+			w.sample(name+"_bucket", labels+`,le="`+formatFloat(bound)+`"`, strconv.FormatInt(cumulative, 10))
+		}
+		w.sample(name+"_bucket", labels+`,le="+Inf"`, strconv.FormatInt(s.count, 10))
+		w.sample(name+"_sum", labels, formatFloat(s.total.Seconds()))
+		w.sample(name+"_count", labels, strconv.FormatInt(s.count, 10))
+	}
+
+	// Ошибки агрегируются по таблице, операции и классу
+	type errorKey struct{ table, operation, class string }
+	errorsByKey := make(map[errorKey]int64)
+	for _, m := range snapshot {
+		for class, n := range m.Errors {
+			errorsByKey[errorKey{m.Table, m.Operation, class}] += n
+		}
+	}
+	keys := make([]errorKey, 0, len(errorsByKey))
+	for k := range errorsByKey {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	name = e.name("query_errors_total")
+	w.header(name, "counter", "Number of failed queries by table, operation and error class.")
+	for _, k := range keys {
+		labels := fmt.Sprintf(`table="%s",operation="%s",class="%s"`,
+			escapeLabel(k.table), escapeLabel(k.operation), escapeLabel(k.class))
+		w.sample(name, labels, strconv.FormatInt(errorsByKey[k], 10))
+	}
+}
+
+func (e *PrometheusExporter) writeCache(w *countingWriter, mc *MetricsCollector) {
+	hits, misses := mc.CacheStats()
+
+	name := e.name("cache_hits_total")
+	w.header(name, "counter", "Number of query cache hits.")
+	w.sample(name, "", strconv.FormatInt(hits, 10))
+
+	name = e.name("cache_misses_total")
+	w.header(name, "counter", "Number of query cache misses.")
+	w.sample(name, "", strconv.FormatInt(misses, 10))
+
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	name = e.name("cache_hit_ratio")
+	w.header(name, "gauge", "Share of query cache lookups that were hits.")
+	w.sample(name, "", formatFloat(ratio))
+}
+
+// poolStats статистика пула соединений основной базы или реплики
+type poolStats struct {
+	name  string
+	stats sql.DBStats
+}
+
+// pools собирает статистику пулов основной базы и реплик
+func (e *PrometheusExporter) pools() []poolStats {
+	type statser interface{ Stats() sql.DBStats }
+	var pools []poolStats
+	if db, ok := e.queryBuilder.db.(statser); ok {
+		pools = append(pools, poolStats{"primary", db.Stats()})
+	}
+	if rs := e.queryBuilder.replicas; rs != nil {
+		for i, replica := range rs.dbs {
+			if db, ok := replica.(statser); ok {
+				pools = append(pools, poolStats{"replica_" + strconv.Itoa(i), db.Stats()})
+			}
+		}
+	}
+	return pools
+}
+
+func (e *PrometheusExporter) writeDBStats(w *countingWriter, pools []poolStats) {
+	metrics := []struct {
+		name, typ, help string
+		value           func(sql.DBStats) string
+	}{
+		{"db_max_open_connections", "gauge", "Maximum number of open connections to the database.", func(s sql.DBStats) string { return strconv.Itoa(s.MaxOpenConnections) }},
+		{"db_open_connections", "gauge", "Number of established connections both in use and idle.", func(s sql.DBStats) string { return strconv.Itoa(s.OpenConnections) }},
+		{"db_in_use_connections", "gauge", "Number of connections currently in use.", func(s sql.DBStats) string { return strconv.Itoa(s.InUse) }},
+		{"db_idle_connections", "gauge", "Number of idle connections.", func(s sql.DBStats) string { return strconv.Itoa(s.Idle) }},
+		{"db_wait_count_total", "counter", "Total number of connections waited for.", func(s sql.DBStats) string { return strconv.FormatInt(s.WaitCount, 10) }},
+		{"db_max_idle_closed_total", "counter", "Total number of connections closed due to SetMaxIdleConns.", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxIdleClosed, 10) }},
+		{"db_max_idle_time_closed_total", "counter", "Total number of connections closed due to SetConnMaxIdleTime.", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxIdleTimeClosed, 10) }},
+		{"db_max_lifetime_closed_total", "counter", "Total number of connections closed due to SetConnMaxLifetime.", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxLifetimeClosed, 10) }},
+		{"db_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.", func(s sql.DBStats) string { return formatFloat(s.WaitDuration.Seconds()) }},
+	}
+	for _, m := range metrics {
+		name := e.name(m.name)
+		w.header(name, m.typ, m.help)
+		for _, pool := range pools {
+			w.sample(name, `pool="`+pool.name+`"`, m.value(pool.stats))
+		}
+	}
+}
+
+func (e *PrometheusExporter) name(metric string) string {
+	if e.namespace == "" {
+		return metric
+	}
+	return e.namespace + "_" + metric
+}
+
+func (s *querySeries) labels() string {
+	labels := fmt.Sprintf(`table="%s",operation="%s"`, escapeLabel(s.table), escapeLabel(s.operation))
+	if s.fingerprint != "" {
+		labels += `,fingerprint="` + escapeLabel(s.fingerprint) + `"`
+	}
+	return labels
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter считает записанные байты и запоминает первую ошибку
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) write(s string) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.WriteString(s)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *countingWriter) header(name, typ, help string) {
+	w.write("# HELP " + name + " " + help + "\n")
+	w.write("# TYPE " + name + " " + typ + "\n")
+}
+
+func (w *countingWriter) sample(name, labels, value string) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	w.write(name + " " + value + "\n")
+}
@@ -3,7 +3,11 @@ package qb
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log/slog"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -13,6 +17,29 @@ type QueryBuilder struct {
 	driverName string
 	cache      CacheInterface
 	logger     *slog.Logger
+	auditTable string
+	auditSink  AuditSink
+
+	outboxTable string
+	metrics     *MetricsCollector
+	hooks       []QueryHook
+	slowLog     *slowQueryLog
+	redaction   *RedactionPolicy
+	nPlusOne    *nPlusOneDetector
+	cacheOpts   CacheOptions
+	flights     flightGroup
+	generations sync.Map
+	replicas    *replicaSet
+	tenant      *tenantMode
+	schemas     *schemaMode
+
+	globalScopes   map[string][]globalScope
+	softDeletes    map[string]SoftDeleteOptions
+	relations      map[string]map[string]Relation
+	versionColumns map[string]string
+	// versionPrecision точность времени в колонках версии
+	versionPrecision time.Duration
+	primaryKeys      map[string]string
 }
 
 func New(driverName string, db *sql.DB) QueryBuilderInterface {
@@ -22,8 +49,23 @@ func NewX(driverName string, db *sqlx.DB) QueryBuilderInterface {
 	return &QueryBuilder{
 		db:         db,
 		driverName: driverName,
-		cache:      NewCacheMemory(),
+		cache:      NewMemoryCache(MemoryCacheOptions{MaxEntries: DefaultMemoryCacheMaxEntries}),
+	}
+}
+
+// NewWithReplicas создает билдер, который направляет чтение на реплики,
+// а запись и транзакции — на основную базу
+func NewWithReplicas(driverName string, primary *sqlx.DB, replicas ...*sqlx.DB) QueryBuilderInterface {
+	q := NewX(driverName, primary).(*QueryBuilder)
+	if len(replicas) > 0 {
+		dbs := make([]DBInterface, len(replicas))
+		for i, replica := range replicas {
+			dbs[i] = replica
+		}
+		q.replicas = &replicaSet{dbs: dbs}
+		q.SetReplicaOptions(ReplicaOptions{})
 	}
+	return q
 }
 
 func (q *QueryBuilder) From(table string) BuilderInterface {
@@ -40,7 +82,8 @@ func (t *Transaction) From(table string) BuilderInterface {
 		tableName:    table,
 		db:           t.Tx,
 		queryBuilder: t.QueryBuilder,
-		ctx:          context.TODO(),
+		ctx:          t.context(),
+		tx:           t,
 	}
 }
 
@@ -50,6 +93,8 @@ func (t *Transaction) Raw(query string, args ...any) *RawQuery {
 		args:         args,
 		db:           t.Tx,
 		queryBuilder: t.QueryBuilder,
+		ctx:          t.context(),
+		tx:           t,
 	}
 }
 
@@ -67,3 +112,41 @@ func (q *QueryBuilder) SetCache(cache CacheInterface) {
 func (q *QueryBuilder) GetCache() CacheInterface {
 	return q.cache
 }
+
+// afterQuery логирует выполненный запрос, передает его в метрики и хуки
+func (q *QueryBuilder) afterQuery(ctx context.Context, msg string, start time.Time, event QueryEvent) {
+	if event.Err == nil && (isWriteOperation(event.Operation) || event.SQL == "COMMIT") {
+		markWrite(ctx)
+	}
+	q.Debug(msg, start, event.SQL, event.Args)
+	if event.Err != nil {
+		q.Error(event.Err.Error(), start, event.SQL, event.Args)
+	}
+	if q.metrics != nil {
+		q.metrics.Record(metricsEvent(event))
+	}
+	q.logSlowQuery(event)
+	for i := len(q.hooks) - 1; i >= 0; i-- {
+		q.hooks[i].AfterQuery(ctx, event)
+	}
+}
+
+// metricsEvent приводит событие к виду для метрик: отсутствие строк не считается ошибкой
+func metricsEvent(event QueryEvent) QueryEvent {
+	if errors.Is(event.Err, sql.ErrNoRows) {
+		event.Err = nil
+	}
+	return event
+}
+
+// resultLen возвращает число элементов в слайсе результата
+func resultLen(dest any) int64 {
+	v := reflect.ValueOf(dest)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		return int64(v.Len())
+	}
+	return 0
+}
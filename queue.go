@@ -0,0 +1,433 @@
+package qb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Статусы задач очереди
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobDead      = "dead"
+)
+
+// DefaultQueueName имя очереди по умолчанию
+const DefaultQueueName = "default"
+
+// QueuedOperation представляет отложенную операцию.
+//
+// Ожидаемая схема таблицы:
+//
+//	id, queue, operation, data, status, priority, attempts, max_attempts,
+//	last_error NULL, run_at, locked_until NULL, locked_by NULL, created_at, updated_at
+type QueuedOperation struct {
+	ID          int64      `db:"id"`
+	Queue       string     `db:"queue"`
+	Operation   string     `db:"operation"`
+	Data        []byte     `db:"data"`
+	Status      string     `db:"status"`
+	Priority    int        `db:"priority"`
+	Attempts    int        `db:"attempts"`
+	MaxAttempts int        `db:"max_attempts"`
+	LastError   *string    `db:"last_error"`
+	RunAt       time.Time  `db:"run_at"`
+	LockedUntil *time.Time `db:"locked_until"`
+	LockedBy    *string    `db:"locked_by"`
+	CreatedAt   time.Time  `db:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at"`
+}
+
+// JobHandler обрабатывает задачу очереди
+type JobHandler func(ctx context.Context, job QueuedOperation) error
+
+// QueueOptions настройки очереди
+type QueueOptions struct {
+	// Table таблица задач, по умолчанию "jobs"
+	Table string
+	// VisibilityTimeout время, после которого незавершенная задача снова доступна
+	VisibilityTimeout time.Duration
+	// MaxAttempts число попыток до перевода задачи в статус dead
+	MaxAttempts int
+	// Backoff задержка перед повторной попыткой
+	Backoff func(attempt int) time.Duration
+	// PollInterval интервал опроса пустой очереди
+	PollInterval time.Duration
+	// Concurrency число одновременно обрабатываемых задач в Work
+	Concurrency int
+}
+
+// EnqueueOptions параметры постановки задачи
+type EnqueueOptions struct {
+	Queue       string
+	Priority    int
+	RunAt       time.Time
+	MaxAttempts int
+}
+
+// JobQueue очередь задач поверх таблицы базы данных
+type JobQueue struct {
+	queryBuilder *QueryBuilder
+	opts         QueueOptions
+}
+
+// JobQueue создает очередь задач
+func (q *QueryBuilder) JobQueue(opts QueueOptions) *JobQueue {
+	if opts.Table == "" {
+		opts.Table = "jobs"
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(5*time.Second, time.Hour)
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &JobQueue{queryBuilder: q, opts: opts}
+}
+
+// ExponentialBackoff возвращает экспоненциальную задержку с ограничением сверху
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// builder создает билдер таблицы задач поверх исполнителя.
+// Захват перечитывает только что обновленные строки, поэтому чтение идет с основной базы
+func (jq *JobQueue) builder(ctx context.Context, db Executor) *Builder {
+	return &Builder{
+		tableName:    jq.opts.Table,
+		db:           db,
+		queryBuilder: jq.queryBuilder,
+		ctx:          ctx,
+		usePrimary:   true,
+	}
+}
+
+// Enqueue добавляет задачу в очередь и возвращает её id
+func (jq *JobQueue) Enqueue(ctx context.Context, operation string, data any, opts EnqueueOptions) (any, error) {
+	return jq.enqueue(ctx, jq.queryBuilder.db, operation, data, opts)
+}
+
+// EnqueueTx добавляет задачу в очередь в рамках транзакции
+func (jq *JobQueue) EnqueueTx(ctx context.Context, tx *Transaction, operation string, data any, opts EnqueueOptions) (any, error) {
+	return jq.enqueue(ctx, tx.Tx, operation, data, opts)
+}
+
+func (jq *JobQueue) enqueue(ctx context.Context, db Executor, operation string, data any, opts EnqueueOptions) (any, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Queue == "" {
+		opts.Queue = DefaultQueueName
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = jq.opts.MaxAttempts
+	}
+	now := time.Now().UTC()
+	if opts.RunAt.IsZero() {
+		opts.RunAt = now
+	}
+
+	return jq.builder(ctx, db).CreateMap(map[string]any{
+		"queue":        opts.Queue,
+		"operation":    operation,
+		"data":         payload,
+		"status":       JobPending,
+		"priority":     opts.Priority,
+		"attempts":     0,
+		"max_attempts": opts.MaxAttempts,
+		"run_at":       opts.RunAt.UTC(),
+		"created_at":   now,
+		"updated_at":   now,
+	})
+}
+
+// Claim атомарно захватывает до limit готовых задач очереди.
+// На PostgreSQL и MySQL 8 используется FOR UPDATE SKIP LOCKED,
+// на остальных драйверах — атомарный UPDATE с меткой захвата.
+func (jq *JobQueue) Claim(ctx context.Context, queue string, limit int) ([]QueuedOperation, error) {
+	if limit <= 0 {
+		limit = 1
+	}
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+
+	ready := fmt.Sprintf(
+		"SELECT id FROM %s WHERE queue = ? AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_until <= ?)) ORDER BY priority DESC, run_at ASC, id ASC LIMIT %d",
+		jq.opts.Table, limit)
+	readyArgs := []any{queue, JobPending, now, JobRunning, now}
+
+	set := fmt.Sprintf("UPDATE %s SET status = ?, attempts = attempts + 1, locked_until = ?, locked_by = ?, updated_at = ?", jq.opts.Table)
+	setArgs := []any{JobRunning, now.Add(jq.opts.VisibilityTimeout), token, now}
+
+	var jobs []QueuedOperation
+	switch jq.queryBuilder.driverName {
+	case "postgres":
+		query := fmt.Sprintf("%s WHERE id IN (%s FOR UPDATE SKIP LOCKED) RETURNING *", set, ready)
+		if _, err := jq.builder(ctx, jq.queryBuilder.db).execSelectContext(ctx, &jobs, query, append(setArgs, readyArgs...)...); err != nil {
+			return nil, err
+		}
+	case "mysql":
+		err := jq.queryBuilder.TransactionContext(ctx, func(tx *Transaction) error {
+			b := jq.builder(ctx, tx.Tx)
+			var ids []any
+			if _, err := b.execSelectContext(ctx, &ids, ready+" FOR UPDATE SKIP LOCKED", readyArgs...); err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			in := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+			if err := b.execExecContext(ctx, fmt.Sprintf("%s WHERE id IN (%s)", set, in), append(setArgs, ids...)...); err != nil {
+				return err
+			}
+			_, err := b.execSelectContext(ctx, &jobs, fmt.Sprintf("SELECT * FROM %s WHERE id IN (%s)", jq.opts.Table, in), ids...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		b := jq.builder(ctx, jq.queryBuilder.db)
+		query := fmt.Sprintf("%s WHERE id IN (%s)", set, ready)
+		if err := b.execExecContext(ctx, query, append(setArgs, readyArgs...)...); err != nil {
+			return nil, err
+		}
+		query = fmt.Sprintf("SELECT * FROM %s WHERE locked_by = ? AND status = ?", jq.opts.Table)
+		if _, err := b.execSelectContext(ctx, &jobs, query, token, JobRunning); err != nil {
+			return nil, err
+		}
+	}
+
+	if jobs, err = jq.deadLetterExhausted(ctx, jobs); err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		if !jobs[i].RunAt.Equal(jobs[j].RunAt) {
+			return jobs[i].RunAt.Before(jobs[j].RunAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
+
+// deadLetterExhausted переводит в статус dead задачи, повторно захваченные после истечения
+// locked_until сверх max_attempts, и возвращает остальные задачи
+func (jq *JobQueue) deadLetterExhausted(ctx context.Context, jobs []QueuedOperation) ([]QueuedOperation, error) {
+	claimed := jobs[:0]
+	for _, job := range jobs {
+		maxAttempts := job.MaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = jq.opts.MaxAttempts
+		}
+		if job.Attempts <= maxAttempts {
+			claimed = append(claimed, job)
+			continue
+		}
+		err := jq.release(ctx, job, map[string]any{
+			"status":       JobDead,
+			"last_error":   fmt.Sprintf("visibility timeout expired after %d attempts", maxAttempts),
+			"locked_until": nil,
+			"locked_by":    nil,
+			"updated_at":   time.Now().UTC(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return claimed, nil
+}
+
+// Complete помечает задачу выполненной
+func (jq *JobQueue) Complete(ctx context.Context, job QueuedOperation) error {
+	return jq.release(ctx, job, map[string]any{
+		"status":       JobCompleted,
+		"locked_until": nil,
+		"locked_by":    nil,
+		"updated_at":   time.Now().UTC(),
+	})
+}
+
+// Fail фиксирует ошибку задачи и планирует повтор
+// либо переводит задачу в статус dead после исчерпания попыток
+func (jq *JobQueue) Fail(ctx context.Context, job QueuedOperation, cause error) error {
+	now := time.Now().UTC()
+	data := map[string]any{
+		"last_error":   cause.Error(),
+		"locked_until": nil,
+		"locked_by":    nil,
+		"updated_at":   now,
+	}
+	maxAttempts := job.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = jq.opts.MaxAttempts
+	}
+	if job.Attempts >= maxAttempts {
+		data["status"] = JobDead
+	} else {
+		data["status"] = JobPending
+		data["run_at"] = now.Add(jq.opts.Backoff(job.Attempts))
+	}
+	return jq.release(ctx, job, data)
+}
+
+// release обновляет задачу, только если она все еще захвачена этим воркером
+func (jq *JobQueue) release(ctx context.Context, job QueuedOperation, data map[string]any) error {
+	b := jq.builder(ctx, jq.queryBuilder.db).Where("id = ?", job.ID)
+	if job.LockedBy != nil {
+		b.Where("locked_by = ?", *job.LockedBy)
+	}
+	return b.UpdateMap(data)
+}
+
+// Process захватывает и обрабатывает готовые задачи, пока очередь не опустеет.
+// Ошибка обработчика не прерывает пакет, а планирует повтор задачи.
+func (jq *JobQueue) Process(ctx context.Context, queue string, handler JobHandler) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		jobs, err := jq.Claim(ctx, queue, jq.opts.Concurrency)
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+		for _, job := range jobs {
+			if err := jq.handle(ctx, job, handler); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Work запускает пул из Concurrency воркеров и обрабатывает очередь до отмены контекста.
+// После отмены новые задачи не захватываются, а начатые дорабатываются.
+func (jq *JobQueue) Work(ctx context.Context, queue string, handler JobHandler) error {
+	slots := make(chan struct{}, jq.opts.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		// Ждем хотя бы один свободный слот
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		free := 1
+	fill:
+		for free < cap(slots) {
+			select {
+			case slots <- struct{}{}:
+				free++
+			default:
+				break fill
+			}
+		}
+
+		jobs, err := jq.Claim(ctx, queue, free)
+		if err != nil {
+			jq.queryBuilder.Warn(fmt.Sprintf("queue %s claim failed: %s", queue, err), time.Now(), "")
+		}
+		for i := len(jobs); i < free; i++ {
+			<-slots
+		}
+
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job QueuedOperation) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if err := jq.handle(context.WithoutCancel(ctx), job, handler); err != nil {
+					jq.queryBuilder.Error(fmt.Sprintf("queue %s job %d result not saved: %s", queue, job.ID, err), time.Now(), "")
+				}
+			}(job)
+		}
+
+		if len(jobs) == 0 {
+			select {
+			case <-time.After(jq.opts.PollInterval):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// handle выполняет обработчик с таймаутом видимости и фиксирует результат
+func (jq *JobQueue) handle(ctx context.Context, job QueuedOperation, handler JobHandler) error {
+	jobCtx, cancel := context.WithTimeout(ctx, jq.opts.VisibilityTimeout)
+	defer cancel()
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("job panic: %v", p)
+			}
+		}()
+		return handler(jobCtx, job)
+	}()
+
+	if err != nil {
+		return jq.Fail(ctx, job, err)
+	}
+	return jq.Complete(ctx, job)
+}
+
+// newLockToken генерирует метку захвата задач
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Queue добавляет операцию в очередь таблицы билдера
+func (qb *Builder) Queue(operation string, data any, runAt time.Time) error {
+	jq := qb.queryBuilder.JobQueue(QueueOptions{Table: qb.tableName})
+	_, err := jq.enqueue(qb.ctx, qb.db, operation, data, EnqueueOptions{RunAt: runAt})
+	return err
+}
+
+// ProcessQueue обрабатывает готовые задачи очереди таблицы билдера
+func (qb *Builder) ProcessQueue(handler func(QueuedOperation) error) error {
+	jq := qb.queryBuilder.JobQueue(QueueOptions{Table: qb.tableName})
+	return jq.Process(qb.ctx, DefaultQueueName, func(_ context.Context, job QueuedOperation) error {
+		return handler(job)
+	})
+}
@@ -1,6 +1,10 @@
 package qb
 
-import "time"
+import (
+	"context"
+	"database/sql"
+	"time"
+)
 
 // Raw выполняет сырой SQL-запрос
 func (q *QueryBuilder) Raw(query string, args ...any) *RawQuery {
@@ -9,6 +13,7 @@ func (q *QueryBuilder) Raw(query string, args ...any) *RawQuery {
 		args:         args,
 		db:           q.db,
 		queryBuilder: q,
+		ctx:          context.TODO(),
 	}
 }
 
@@ -18,37 +23,75 @@ type RawQuery struct {
 	args         []any
 	db           Executor
 	queryBuilder *QueryBuilder
+	ctx          context.Context
+	tx           *Transaction
+}
+
+// Context задает контекст выполнения запроса
+func (r *RawQuery) Context(ctx context.Context) *RawQuery {
+	r.ctx = ctx
+	return r
 }
 
 // Exec выполняет запрос без возврата результатов
 func (r *RawQuery) Exec() error {
+	ctx, event, err := r.beforeQuery()
 	start := time.Now()
-	r.queryBuilder.logger.Debug("RawQuery", start, r.query, r.args)
-	_, err := r.db.Exec(r.query, r.args...)
-	if err != nil {
-		r.queryBuilder.Error(err.Error(), start, r.query, r.args)
+	var result sql.Result
+	var rows int64
+	if err == nil {
+		result, err = r.db.ExecContext(ctx, r.query, r.args...)
+	}
+	if err == nil {
+		rows, _ = result.RowsAffected()
 	}
+	r.afterQuery(ctx, "RawQuery", start, event, rows, err)
 	return err
 }
 
 // Query выполняет запрос и сканирует результаты в slice
 func (r *RawQuery) Query(dest any) error {
+	ctx, event, err := r.beforeQuery()
 	start := time.Now()
-	r.queryBuilder.Debug("RawQuery", start, r.query, r.args)
-	err := r.db.Select(dest, r.query, r.args...)
-	if err != nil {
-		r.queryBuilder.Error(err.Error(), start, r.query, r.args)
+	if err == nil {
+		err = selectInto(ctx, r.db, dest, r.query, r.args...)
 	}
+	r.afterQuery(ctx, "RawQuery", start, event, resultLen(dest), err)
 	return err
 }
 
 // QueryRow выполняет запрос и сканирует один результат
 func (r *RawQuery) QueryRow(dest any) error {
+	ctx, event, err := r.beforeQuery()
 	start := time.Now()
-	r.queryBuilder.Debug("QueryRow", start, r.query, r.args)
-	err := r.db.Get(dest, r.query, r.args...)
-	if err != nil {
-		r.queryBuilder.Error(err.Error(), start, r.query, r.args)
+	if err == nil {
+		err = getInto(ctx, r.db, dest, r.query, r.args...)
 	}
+	var rows int64
+	if err == nil {
+		rows = 1
+	}
+	r.afterQuery(ctx, "QueryRow", start, event, rows, err)
 	return err
 }
+
+// beforeQuery готовит событие запроса и вызывает хуки перед выполнением
+func (r *RawQuery) beforeQuery() (context.Context, QueryEvent, error) {
+	event := QueryEvent{
+		Operation: queryOperation(r.query),
+		Table:     queryTable(r.query),
+		SQL:       r.query,
+		Args:      r.args,
+	}
+	ctx, err := r.queryBuilder.beforeQuery(r.ctx, event)
+	return ctx, event, err
+}
+
+// afterQuery логирует выполненный запрос, передает его в метрики и хуки
+func (r *RawQuery) afterQuery(ctx context.Context, msg string, start time.Time, event QueryEvent, rows int64, err error) {
+	event.Duration = time.Since(start)
+	event.Rows = rows
+	event.Err = err
+	r.queryBuilder.afterQuery(ctx, msg, start, event)
+	r.queryBuilder.invalidateAfterWrite(r.tx, event)
+}
@@ -0,0 +1,247 @@
+package qb
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// DefaultRedactionMask значение, подставляемое вместо скрытых аргументов
+const DefaultRedactionMask = "[REDACTED]"
+
+// Redactable реализуется типами, которые сами определяют свое представление в логах
+type Redactable interface {
+	Redacted() any
+}
+
+// RedactionPolicy правила скрытия аргументов запросов в логах
+type RedactionPolicy struct {
+	// Columns шаблоны колонок, значения которых скрываются, например "password" или "*_token"
+	Columns []string
+	// MaxArgLength максимальная длина строк и []byte в логе, 0 — без ограничения
+	MaxArgLength int
+	// SQLOnly отключает вывод аргументов
+	SQLOnly bool
+	// Mask значение вместо скрытого аргумента, по умолчанию DefaultRedactionMask
+	Mask string
+}
+
+// SetRedaction задает правила скрытия аргументов в логах запросов
+func (q *QueryBuilder) SetRedaction(policy RedactionPolicy) {
+	if policy.Mask == "" {
+		policy.Mask = DefaultRedactionMask
+	}
+	columns := make([]string, len(policy.Columns))
+	for i, pattern := range policy.Columns {
+		columns[i] = strings.ToLower(pattern)
+	}
+	policy.Columns = columns
+	q.redaction = &policy
+}
+
+// argsAttr возвращает атрибут лога с аргументами запроса с учетом правил скрытия
+func (q *QueryBuilder) argsAttr(query string, args []any) (slog.Attr, bool) {
+	// Внутренние вызовы передают аргументы одним слайсом
+	if len(args) == 1 {
+		if nested, ok := args[0].([]any); ok {
+			args = nested
+		}
+	}
+
+	policy := q.redaction
+	if policy == nil {
+		return slog.Any("arg", args), true
+	}
+	if policy.SQLOnly {
+		return slog.Attr{}, false
+	}
+
+	var columns []string
+	if len(policy.Columns) > 0 {
+		columns = placeholderColumns(query)
+	}
+
+	redacted := make([]any, len(args))
+	for i, arg := range args {
+		if i < len(columns) && policy.matchColumn(columns[i]) {
+			redacted[i] = policy.Mask
+			continue
+		}
+		redacted[i] = policy.redactValue(arg)
+	}
+	return slog.Any("arg", redacted), true
+}
+
+// matchColumn проверяет, подпадает ли колонка под правила скрытия
+func (p *RedactionPolicy) matchColumn(column string) bool {
+	if column == "" {
+		return false
+	}
+	column = strings.ToLower(column)
+	for _, pattern := range p.Columns {
+		if ok, _ := path.Match(pattern, column); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue применяет Redactable и ограничение длины
+func (p *RedactionPolicy) redactValue(arg any) any {
+	if r, ok := arg.(Redactable); ok {
+		return r.Redacted()
+	}
+	if p.MaxArgLength <= 0 {
+		return arg
+	}
+	switch v := arg.(type) {
+	case string:
+		if len(v) > p.MaxArgLength {
+			return fmt.Sprintf("%s...(%d bytes)", v[:p.MaxArgLength], len(v))
+		}
+	case []byte:
+		if len(v) > p.MaxArgLength {
+			return fmt.Sprintf("%s...(%d bytes)", v[:p.MaxArgLength], len(v))
+		}
+	}
+	return arg
+}
+
+// placeholderColumns сопоставляет плейсхолдерам запроса имена колонок.
+// Поддерживаются сравнения "col = ?", списки IN, BETWEEN, SET, CASE WHEN/THEN
+// и списки колонок INSERT. Для неопознанных плейсхолдеров возвращается пустая строка.
+func placeholderColumns(query string) []string {
+	var (
+		columns     []string
+		insertCols  []string
+		inValues    bool
+		valuesIndex int
+		lastIdent   string
+		identBefore bool
+		pending     string
+		keepPending int
+		inList      bool
+		depth       int
+		caseCols    []string
+		caseOperand string
+	)
+
+	isInsert := queryOperation(query) == "insert"
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			for i++; i < len(query) && query[i] != '\''; i++ {
+			}
+			identBefore = false
+		case c == '?' || (c == '$' && i+1 < len(query) && isDigit(query[i+1])):
+			for c == '$' && i+1 < len(query) && isDigit(query[i+1]) {
+				i++
+			}
+			col := pending
+			if inValues && len(insertCols) > 0 {
+				col = insertCols[valuesIndex%len(insertCols)]
+				valuesIndex++
+			}
+			columns = append(columns, col)
+			if keepPending > 0 {
+				keepPending--
+			} else if !inList {
+				pending = ""
+			}
+			identBefore = false
+		case isIdentChar(c) || c == '.' || c == '"' || c == '`':
+			start := i
+			for i+1 < len(query) && (isIdentChar(query[i+1]) || query[i+1] == '.' || query[i+1] == '"' || query[i+1] == '`') {
+				i++
+			}
+			word := strings.Trim(query[start:i+1], "\"`")
+			if dot := strings.LastIndexByte(word, '.'); dot >= 0 {
+				word = strings.Trim(word[dot+1:], "\"`")
+			}
+			switch strings.ToUpper(word) {
+			case "IN":
+				if identBefore {
+					pending = lastIdent
+					inList = true
+				}
+				identBefore = false
+			case "LIKE", "ILIKE":
+				if identBefore {
+					pending = lastIdent
+				}
+				identBefore = false
+			case "BETWEEN":
+				if identBefore {
+					pending = lastIdent
+					keepPending = 1
+				}
+				identBefore = false
+			case "CASE":
+				caseCols = append(caseCols, pending)
+				caseOperand = ""
+				identBefore = false
+			case "WHEN":
+				pending = caseOperand
+				identBefore = false
+			case "THEN", "ELSE":
+				if len(caseCols) > 0 {
+					pending = caseCols[len(caseCols)-1]
+				}
+				identBefore = false
+			case "END":
+				if len(caseCols) > 0 {
+					caseCols = caseCols[:len(caseCols)-1]
+				}
+				pending = ""
+				identBefore = false
+			case "VALUES":
+				if isInsert {
+					inValues = true
+				}
+				identBefore = false
+			case "AND", "OR", "NOT", "WHERE", "SET", "ON", "HAVING", "SELECT", "FROM", "IS", "NULL", "LIMIT", "OFFSET":
+				identBefore = false
+			default:
+				if len(caseCols) > 0 && caseOperand == "" && pending == caseCols[len(caseCols)-1] {
+					caseOperand = word
+				}
+				lastIdent = word
+				identBefore = true
+			}
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			for i+1 < len(query) && strings.IndexByte("=<>", query[i+1]) >= 0 {
+				i++
+			}
+			if identBefore {
+				pending = lastIdent
+			}
+			identBefore = false
+		case c == '(':
+			depth++
+			if isInsert && !inValues && insertCols == nil && depth == 1 {
+				insertCols = []string{}
+			}
+			identBefore = false
+		case c == ')':
+			depth--
+			inList = false
+			identBefore = false
+		case c == ',':
+			if isInsert && !inValues && insertCols != nil && depth == 1 && identBefore {
+				insertCols = append(insertCols, lastIdent)
+			}
+			identBefore = false
+		}
+
+		// Последняя колонка списка INSERT перед закрывающей скобкой
+		if c == ')' && isInsert && !inValues && insertCols != nil && depth == 0 && lastIdent != "" {
+			if len(insertCols) == 0 || insertCols[len(insertCols)-1] != lastIdent {
+				insertCols = append(insertCols, lastIdent)
+			}
+		}
+	}
+	return columns
+}
@@ -0,0 +1,415 @@
+package qb
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// RelationType тип связи между таблицами
+type RelationType int
+
+const (
+	// HasOne у родителя одна связанная запись, ключ хранится в связанной таблице
+	HasOne RelationType = iota
+	// HasMany у родителя много связанных записей, ключ хранится в связанной таблице
+	HasMany
+	// BelongsTo родитель ссылается на связанную запись своим ключом
+	BelongsTo
+	// ManyToMany связь через промежуточную таблицу
+	ManyToMany
+)
+
+// Relation описание связи. Связь объявляется через QueryBuilder.AddRelation
+// или тегом rel на поле структуры, например:
+//
+//	Orders []Order `db:"-" rel:"has_many,table=orders,foreign_key=user_id"`
+//	Roles  []Role  `db:"-" rel:"many_to_many,table=roles,pivot=user_roles,pivot_foreign_key=user_id,pivot_related_key=role_id"`
+type Relation struct {
+	Type  RelationType
+	Table string
+	// ForeignKey для HasOne и HasMany колонка связанной таблицы, для BelongsTo колонка родителя
+	ForeignKey string
+	// LocalKey ключ родителя для HasOne, HasMany и ManyToMany, по умолчанию id
+	LocalKey string
+	// OwnerKey ключ связанной таблицы для BelongsTo и ManyToMany, по умолчанию id
+	OwnerKey string
+	// Pivot промежуточная таблица ManyToMany
+	Pivot string
+	// PivotForeignKey колонка промежуточной таблицы со ссылкой на родителя
+	PivotForeignKey string
+	// PivotRelatedKey колонка промежуточной таблицы со ссылкой на связанную запись
+	PivotRelatedKey string
+	// Constraint условия, которые всегда добавляются к запросу связи
+	Constraint func(*Builder)
+}
+
+var relationTypes = map[string]RelationType{
+	"has_one":      HasOne,
+	"has_many":     HasMany,
+	"belongs_to":   BelongsTo,
+	"many_to_many": ManyToMany,
+}
+
+// AddRelation регистрирует связь таблицы под именем поля структуры
+func (q *QueryBuilder) AddRelation(table string, name string, rel Relation) {
+	if q.relations == nil {
+		q.relations = make(map[string]map[string]Relation)
+	}
+	table = normalizeTable(table)
+	if q.relations[table] == nil {
+		q.relations[table] = make(map[string]Relation)
+	}
+	q.relations[table][name] = rel.withDefaults()
+}
+
+func (rel Relation) withDefaults() Relation {
+	if rel.LocalKey == "" {
+		rel.LocalKey = "id"
+	}
+	if rel.OwnerKey == "" {
+		rel.OwnerKey = "id"
+	}
+	return rel
+}
+
+// parseRelationTag разбирает тег rel
+func parseRelationTag(tag string) (Relation, error) {
+	parts := strings.Split(tag, ",")
+	relType, ok := relationTypes[strings.TrimSpace(parts[0])]
+	if !ok {
+		return Relation{}, fmt.Errorf("qb: unknown relation type %q", parts[0])
+	}
+	rel := Relation{Type: relType}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "table":
+			rel.Table = value
+		case "foreign_key":
+			rel.ForeignKey = value
+		case "local_key":
+			rel.LocalKey = value
+		case "owner_key":
+			rel.OwnerKey = value
+		case "pivot":
+			rel.Pivot = value
+		case "pivot_foreign_key":
+			rel.PivotForeignKey = value
+		case "pivot_related_key":
+			rel.PivotRelatedKey = value
+		default:
+			return Relation{}, fmt.Errorf("qb: unknown relation option %q", key)
+		}
+	}
+	return rel.withDefaults(), nil
+}
+
+// relation находит связь таблицы: зарегистрированную или из тега поля структуры
+func (q *QueryBuilder) relation(table string, structType reflect.Type, name string) (Relation, error) {
+	if rel, ok := q.relations[normalizeTable(table)][name]; ok {
+		return rel, nil
+	}
+	if structType != nil {
+		if field, ok := structType.FieldByName(name); ok {
+			if tag := field.Tag.Get("rel"); tag != "" {
+				return parseRelationTag(tag)
+			}
+		}
+	}
+	return Relation{}, fmt.Errorf("qb: relation %s of %s is not defined", name, table)
+}
+
+// With включает жадную загрузку связей. Вложенные связи указываются через точку:
+// With("Orders", "Orders.Items")
+func (qb *Builder) With(relations ...string) *Builder {
+	for _, path := range relations {
+		if !qb.hasWith(path) {
+			qb.with = append(qb.with, path)
+		}
+	}
+	return qb
+}
+
+// WithQuery включает жадную загрузку связи с дополнительными условиями
+func (qb *Builder) WithQuery(relation string, fn func(*Builder)) *Builder {
+	qb.With(relation)
+	if qb.withConstraints == nil {
+		qb.withConstraints = make(map[string]func(*Builder))
+	}
+	qb.withConstraints[relation] = fn
+	return qb
+}
+
+func (qb *Builder) hasWith(path string) bool {
+	for _, p := range qb.with {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRelations загружает связи из With для полученных записей
+func (qb *Builder) loadRelations(dest any) error {
+	parents, structType := collectStructs(dest)
+	if len(parents) == 0 {
+		return nil
+	}
+
+	// Связи первого уровня в порядке объявления и их вложенные связи
+	var names []string
+	nested := make(map[string][]string)
+	for _, path := range qb.with {
+		name, rest, _ := strings.Cut(path, ".")
+		if _, ok := nested[name]; !ok {
+			names = append(names, name)
+			nested[name] = nil
+		}
+		if rest != "" {
+			nested[name] = append(nested[name], rest)
+		}
+	}
+
+	for _, name := range names {
+		rel, err := qb.queryBuilder.relation(qb.tableName, structType, name)
+		if err != nil {
+			return err
+		}
+		field, ok := structType.FieldByName(name)
+		if !ok {
+			return fmt.Errorf("qb: %s has no field %s", structType, name)
+		}
+		child := qb.related(rel.Table)
+		if rel.Constraint != nil {
+			rel.Constraint(child)
+		}
+		if fn := qb.withConstraints[name]; fn != nil {
+			fn(child)
+		}
+		child.with = nested[name]
+		for path, fn := range qb.withConstraints {
+			if rest, ok := strings.CutPrefix(path, name+"."); ok {
+				if child.withConstraints == nil {
+					child.withConstraints = make(map[string]func(*Builder))
+				}
+				child.withConstraints[rest] = fn
+			}
+		}
+		if err := child.eagerLoad(rel, parents, field); err != nil {
+			return fmt.Errorf("relation %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// eagerLoad выполняет один запрос IN для связи и раскладывает результаты по родителям
+func (qb *Builder) eagerLoad(rel Relation, parents []reflect.Value, field reflect.StructField) error {
+	parentKey := rel.LocalKey
+	if rel.Type == BelongsTo {
+		parentKey = rel.ForeignKey
+	}
+	keys := distinctKeys(parents, parentKey)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Для ManyToMany ключи родителей сопоставляются со связанными записями через промежуточную таблицу
+	var pivot map[string][]string
+	childKey := rel.ForeignKey
+	switch rel.Type {
+	case BelongsTo:
+		childKey = rel.OwnerKey
+	case ManyToMany:
+		var err error
+		if pivot, keys, err = qb.loadPivot(rel, keys); err != nil || len(keys) == 0 {
+			return err
+		}
+		childKey = rel.OwnerKey
+	}
+
+	elemType := field.Type
+	if elemType.Kind() == reflect.Slice {
+		elemType = elemType.Elem()
+	}
+	structElem := elemType
+	if structElem.Kind() == reflect.Ptr {
+		structElem = structElem.Elem()
+	}
+	children := reflect.New(reflect.SliceOf(structElem))
+	if err := qb.getIn(qb.QualifiedColumn(childKey), keys, children); err != nil {
+		return err
+	}
+
+	byKey := make(map[string][]reflect.Value)
+	list := children.Elem()
+	for i := 0; i < list.Len(); i++ {
+		item := list.Index(i)
+		key, ok := columnKey(item, childKey)
+		if !ok {
+			return fmt.Errorf("qb: %s has no column %s", structElem, childKey)
+		}
+		byKey[key] = append(byKey[key], item)
+	}
+
+	for _, parent := range parents {
+		key, ok := columnKey(parent, parentKey)
+		if !ok {
+			return fmt.Errorf("qb: %s has no column %s", parent.Type(), parentKey)
+		}
+		var matched []reflect.Value
+		if pivot != nil {
+			for _, relatedKey := range pivot[key] {
+				matched = append(matched, byKey[relatedKey]...)
+			}
+		} else {
+			matched = byKey[key]
+		}
+		assignRelation(parent.FieldByIndex(field.Index), matched)
+	}
+	return nil
+}
+
+// loadPivot читает промежуточную таблицу и возвращает связи родитель -> связанные ключи
+func (qb *Builder) loadPivot(rel Relation, keys []any) (map[string][]string, []any, error) {
+	var rows []struct {
+		ParentKey  any `db:"parent_key"`
+		RelatedKey any `db:"related_key"`
+	}
+	err := qb.related(rel.Pivot).
+		Select(rel.PivotForeignKey+" AS parent_key", rel.PivotRelatedKey+" AS related_key").
+		getIn(rel.PivotForeignKey, keys, reflect.ValueOf(&rows))
+	if err != nil {
+		return nil, nil, err
+	}
+	pivot := make(map[string][]string, len(keys))
+	seen := make(map[string]bool)
+	var related []any
+	for _, row := range rows {
+		parentKey, relatedKey := relationKey(row.ParentKey), relationKey(row.RelatedKey)
+		pivot[parentKey] = append(pivot[parentKey], relatedKey)
+		if !seen[relatedKey] {
+			seen[relatedKey] = true
+			related = append(related, derefValue(row.RelatedKey))
+		}
+	}
+	return pivot, related, nil
+}
+
+// eagerLoadChunkSize наибольшее число ключей в одном IN жадной загрузки
+const eagerLoadChunkSize = 1000
+
+// getIn добавляет к dest, указателю на срез, записи билдера с column IN keys.
+// Ключи делятся на части по eagerLoadChunkSize, каждая выбирается отдельным запросом
+func (qb *Builder) getIn(column string, keys []any, dest reflect.Value) error {
+	for start := 0; start < len(keys); start += eagerLoadChunkSize {
+		chunk := *qb
+		chunk.conditions = slices.Clip(qb.conditions)
+		part := reflect.New(dest.Type().Elem())
+		end := min(start+eagerLoadChunkSize, len(keys))
+		if _, err := chunk.WhereIn(column, keys[start:end]...).Get(part.Interface()); err != nil {
+			return err
+		}
+		dest.Elem().Set(reflect.AppendSlice(dest.Elem(), part.Elem()))
+	}
+	return nil
+}
+
+// assignRelation записывает найденные записи в поле родителя
+func assignRelation(field reflect.Value, matched []reflect.Value) {
+	item := func(v reflect.Value, t reflect.Type) reflect.Value {
+		if t.Kind() == reflect.Ptr {
+			return v.Addr()
+		}
+		return v
+	}
+	if field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), 0, len(matched))
+		for _, v := range matched {
+			slice = reflect.Append(slice, item(v, field.Type().Elem()))
+		}
+		field.Set(slice)
+		return
+	}
+	if len(matched) == 0 {
+		field.SetZero()
+		return
+	}
+	field.Set(item(matched[0], field.Type()))
+}
+
+// collectStructs возвращает адресуемые структуры из указателя на структуру или срез структур
+func collectStructs(dest any) ([]reflect.Value, reflect.Type) {
+	v := reflect.ValueOf(dest)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{v}, v.Type()
+	case reflect.Slice:
+		elemType := v.Type().Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if elemType.Kind() != reflect.Struct {
+			return nil, nil
+		}
+		result := make([]reflect.Value, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i)
+			for item.Kind() == reflect.Ptr && !item.IsNil() {
+				item = item.Elem()
+			}
+			if item.Kind() == reflect.Struct {
+				result = append(result, item)
+			}
+		}
+		return result, elemType
+	}
+	return nil, nil
+}
+
+// distinctKeys собирает уникальные непустые значения колонки
+func distinctKeys(items []reflect.Value, column string) []any {
+	seen := make(map[string]bool, len(items))
+	var keys []any
+	for _, item := range items {
+		field, ok := structColumn(item, column)
+		if !ok {
+			continue
+		}
+		value := derefValue(field.Interface())
+		if value == nil {
+			continue
+		}
+		key := relationKey(value)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, value)
+		}
+	}
+	return keys
+}
+
+// columnKey возвращает значение колонки структуры в виде ключа сопоставления
+func columnKey(item reflect.Value, column string) (string, bool) {
+	field, ok := structColumn(item, column)
+	if !ok {
+		return "", false
+	}
+	return relationKey(field.Interface()), true
+}
+
+// relationKey приводит значение ключа к строке, чтобы int64 из базы совпадал с int поля
+func relationKey(v any) string {
+	v = derefValue(v)
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
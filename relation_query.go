@@ -0,0 +1,146 @@
+package qb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// selectExpr дополнительная колонка выборки с аргументами
+type selectExpr struct {
+	sql  string
+	args []any
+	// subQuery подставляется в sql вместо subQueryPlaceholder
+	subQuery *Builder
+}
+
+// AddModelRelations регистрирует связи из тегов rel структуры,
+// чтобы их можно было использовать в WhereHas и WithCount без экземпляра модели
+func (q *QueryBuilder) AddModelRelations(table string, model any) error {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("qb: model must be a struct, got %s", t)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("rel")
+		if tag == "" {
+			continue
+		}
+		rel, err := parseRelationTag(tag)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", t, t.Field(i).Name, err)
+		}
+		q.AddRelation(table, t.Field(i).Name, rel)
+	}
+	return nil
+}
+
+// WhereHas оставляет записи, у которых есть хотя бы minCount связанных записей,
+// подходящих под условия fn. fn может быть nil
+func (qb *Builder) WhereHas(relation string, fn func(*Builder), minCount int) *Builder {
+	sub, ok := qb.relationQuery(relation, fn)
+	if !ok {
+		return qb
+	}
+	if minCount <= 1 {
+		return qb.WhereExists(sub.Select("1"))
+	}
+	qb.conditions = append(qb.conditions, Condition{
+		operator: "AND",
+		clause:   "(" + subQueryPlaceholder + ") >= ?",
+		args:     []any{minCount},
+		subQuery: sub.Select("COUNT(*)"),
+	})
+	return qb
+}
+
+// WhereDoesntHave оставляет записи без связанных записей, подходящих под условия fn
+func (qb *Builder) WhereDoesntHave(relation string, fn func(*Builder)) *Builder {
+	sub, ok := qb.relationQuery(relation, fn)
+	if !ok {
+		return qb
+	}
+	return qb.WhereNotExists(sub.Select("1"))
+}
+
+// WithCount добавляет в выборку число связанных записей в колонке <relation>_count
+func (qb *Builder) WithCount(relation string) *Builder {
+	return qb.withAggregate(relation, "COUNT(*)", snakeCase(relation)+"_count")
+}
+
+// WithSum добавляет в выборку сумму колонки связанных записей в колонке <relation>_sum_<column>
+func (qb *Builder) WithSum(relation string, column string) *Builder {
+	expr := fmt.Sprintf("COALESCE(SUM(%s), 0)", column)
+	return qb.withAggregate(relation, expr, snakeCase(relation)+"_sum_"+column)
+}
+
+// withAggregate добавляет коррелированный подзапрос связи в список колонок
+func (qb *Builder) withAggregate(relation string, expr string, alias string) *Builder {
+	sub, ok := qb.relationQuery(relation, nil)
+	if !ok {
+		return qb
+	}
+	qb.extraColumns = append(qb.extraColumns, selectExpr{
+		sql:      fmt.Sprintf("(%s) AS %s", subQueryPlaceholder, alias),
+		subQuery: sub.Select(expr),
+	})
+	return qb
+}
+
+// relationQuery строит подзапрос к связанной таблице, коррелированный с текущей
+func (qb *Builder) relationQuery(relation string, fn func(*Builder)) (*Builder, bool) {
+	rel, err := qb.queryBuilder.relation(qb.tableName, nil, relation)
+	if err != nil {
+		qb.err = err
+		return nil, false
+	}
+
+	parentRef := qb.tableName
+	if qb.alias != "" {
+		parentRef = qb.alias
+	}
+	sub := qb.related(rel.Table)
+	childRef := rel.Table
+	// Для связи таблицы с самой собой подзапросу нужен собственный псевдоним
+	if normalizeTable(rel.Table) == normalizeTable(qb.tableName) {
+		childRef = rel.Table + "_related"
+		sub.As(childRef)
+	}
+
+	switch rel.Type {
+	case HasOne, HasMany:
+		sub.Where(fmt.Sprintf("%s.%s = %s.%s", childRef, rel.ForeignKey, parentRef, rel.LocalKey))
+	case BelongsTo:
+		sub.Where(fmt.Sprintf("%s.%s = %s.%s", childRef, rel.OwnerKey, parentRef, rel.ForeignKey))
+	case ManyToMany:
+		sub.Join(rel.Pivot, fmt.Sprintf("%s.%s = %s.%s", rel.Pivot, rel.PivotRelatedKey, childRef, rel.OwnerKey))
+		sub.Where(fmt.Sprintf("%s.%s = %s.%s", rel.Pivot, rel.PivotForeignKey, parentRef, rel.LocalKey))
+	}
+	if rel.Constraint != nil {
+		rel.Constraint(sub)
+	}
+	if fn != nil {
+		fn(sub)
+	}
+	return sub, true
+}
+
+// snakeCase переводит имя поля в имя колонки: OrderItems -> order_items
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
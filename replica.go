@@ -0,0 +1,116 @@
+package qb
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPolicy политика выбора реплики для чтения
+type ReplicaPolicy int
+
+const (
+	// ReplicaRoundRobin перебирает реплики по кругу
+	ReplicaRoundRobin ReplicaPolicy = iota
+	// ReplicaRandom выбирает случайную реплику
+	ReplicaRandom
+	// ReplicaLeastConnections выбирает реплику с наименьшим числом занятых соединений
+	ReplicaLeastConnections
+)
+
+// DefaultStickyWindow окно чтения с основной базы после записи по умолчанию
+const DefaultStickyWindow = 5 * time.Second
+
+// ReplicaOptions настройки маршрутизации чтения
+type ReplicaOptions struct {
+	Policy ReplicaPolicy
+	// StickyWindow сколько после записи читать с основной базы
+	// в контексте из WithReadYourWrites, по умолчанию DefaultStickyWindow
+	StickyWindow time.Duration
+}
+
+// replicaSet реплики и состояние маршрутизации
+type replicaSet struct {
+	dbs  []DBInterface
+	opts ReplicaOptions
+	next atomic.Uint64
+}
+
+var lockingReadRe = regexp.MustCompile(`(?i)\bFOR\s+(?:NO\s+KEY\s+)?(?:UPDATE|SHARE|KEY\s+SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b`)
+
+// SetReplicaOptions задает политику выбора реплики и окно чтения после записи
+func (q *QueryBuilder) SetReplicaOptions(opts ReplicaOptions) {
+	if q.replicas == nil {
+		return
+	}
+	if opts.StickyWindow <= 0 {
+		opts.StickyWindow = DefaultStickyWindow
+	}
+	q.replicas.opts = opts
+}
+
+// UsePrimary направляет чтение билдера на основную базу
+func (qb *Builder) UsePrimary() *Builder {
+	qb.usePrimary = true
+	return qb
+}
+
+type stickyKey struct{}
+
+// stickySession время последней записи в пределах контекста
+type stickySession struct {
+	lastWrite atomic.Int64
+}
+
+// WithReadYourWrites включает чтение с основной базы в течение StickyWindow
+// после каждой записи, выполненной с этим контекстом
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stickyKey{}, &stickySession{})
+}
+
+// markWrite запоминает время записи для контекста с WithReadYourWrites
+func markWrite(ctx context.Context) {
+	if session, ok := ctx.Value(stickyKey{}).(*stickySession); ok {
+		session.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+// readExecutor выбирает исполнитель для чтения: реплику или основную базу
+func (qb *Builder) readExecutor(ctx context.Context, query string) Executor {
+	rs := qb.queryBuilder.replicas
+	if rs == nil || qb.usePrimary || qb.tx != nil || any(qb.db) != any(qb.queryBuilder.db) ||
+		queryOperation(query) != "select" || lockingReadRe.MatchString(query) {
+		return qb.getExecutor()
+	}
+	if session, ok := ctx.Value(stickyKey{}).(*stickySession); ok {
+		if last := session.lastWrite.Load(); last > 0 && time.Since(time.Unix(0, last)) < rs.opts.StickyWindow {
+			return qb.getExecutor()
+		}
+	}
+	return rs.pick()
+}
+
+// pick выбирает реплику по политике
+func (rs *replicaSet) pick() DBInterface {
+	switch rs.opts.Policy {
+	case ReplicaRandom:
+		return rs.dbs[rand.Intn(len(rs.dbs))]
+	case ReplicaLeastConnections:
+		best, bestInUse := rs.dbs[0], -1
+		for _, db := range rs.dbs {
+			stats, ok := db.(interface{ Stats() sql.DBStats })
+			if !ok {
+				continue
+			}
+			if inUse := stats.Stats().InUse; bestInUse < 0 || inUse < bestInUse {
+				best, bestInUse = db, inUse
+			}
+		}
+		return best
+	default:
+		return rs.dbs[(rs.next.Add(1)-1)%uint64(len(rs.dbs))]
+	}
+}
@@ -0,0 +1,183 @@
+package qb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidSchema недопустимое имя схемы
+var ErrInvalidSchema = errors.New("qb: invalid schema name")
+
+// SchemaMode способ выбора схемы
+type SchemaMode int
+
+const (
+	// SchemaQualify добавляет схему к именам таблиц: schema.table
+	SchemaQualify SchemaMode = iota
+	// SchemaSearchPath выполняет SET LOCAL search_path в транзакциях Postgres,
+	// общие таблицы при этом ищутся в public. Вне транзакции имена таблиц по-прежнему уточняются схемой,
+	// так как соединение из пула не может безопасно хранить search_path
+	SchemaSearchPath
+)
+
+// SchemaOptions настройки схем арендаторов
+type SchemaOptions struct {
+	Mode SchemaMode
+	// Resolve определяет схему по контексту, по умолчанию SchemaFromContext
+	Resolve func(ctx context.Context) (string, bool)
+	// Shared таблицы общей схемы, которые не уточняются
+	Shared []string
+	// List возвращает все схемы арендаторов для ForEachSchema
+	List func(ctx context.Context) ([]string, error)
+}
+
+// schemaMode включенный режим схем
+type schemaMode struct {
+	mode    SchemaMode
+	resolve func(ctx context.Context) (string, bool)
+	shared  map[string]bool
+	list    func(ctx context.Context) ([]string, error)
+}
+
+type schemaKey struct{}
+
+var schemaNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SetSchemaOptions включает выбор схемы арендатора для запросов
+func (q *QueryBuilder) SetSchemaOptions(opts SchemaOptions) {
+	if opts.Resolve == nil {
+		opts.Resolve = SchemaFromContext
+	}
+	shared := make(map[string]bool, len(opts.Shared))
+	for _, table := range opts.Shared {
+		shared[normalizeTable(table)] = true
+	}
+	q.schemas = &schemaMode{mode: opts.Mode, resolve: opts.Resolve, shared: shared, list: opts.List}
+}
+
+// WithSchema добавляет схему в контекст
+func WithSchema(ctx context.Context, schema string) context.Context {
+	return context.WithValue(ctx, schemaKey{}, schema)
+}
+
+// SchemaFromContext возвращает схему из контекста
+func SchemaFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	schema, _ := ctx.Value(schemaKey{}).(string)
+	return schema, schema != ""
+}
+
+// SchemaQuery запросы к таблицам одной схемы
+type SchemaQuery struct {
+	queryBuilder *QueryBuilder
+	schema       string
+}
+
+// ForSchema возвращает запросы к таблицам указанной схемы
+func (q *QueryBuilder) ForSchema(name string) *SchemaQuery {
+	return &SchemaQuery{queryBuilder: q, schema: name}
+}
+
+// Name возвращает имя схемы
+func (s *SchemaQuery) Name() string {
+	return s.schema
+}
+
+// From создает билдер для таблицы схемы
+func (s *SchemaQuery) From(table string) BuilderInterface {
+	qb := s.queryBuilder.From(table).(*Builder)
+	qb.schema = s.schema
+	return qb
+}
+
+// TransactionContext выполняет функцию в транзакции, привязанной к схеме
+func (s *SchemaQuery) TransactionContext(ctx context.Context, fn func(*Transaction) error) error {
+	return s.queryBuilder.TransactionContext(WithSchema(ctx, s.schema), fn)
+}
+
+// ForEachSchema последовательно выполняет fn для каждой схемы из SchemaOptions.List.
+// Останавливается на первой ошибке
+func (q *QueryBuilder) ForEachSchema(ctx context.Context, fn func(ctx context.Context, schema *SchemaQuery) error) error {
+	if q.schemas == nil || q.schemas.list == nil {
+		return errors.New("qb: schema list is not configured")
+	}
+	schemas, err := q.schemas.list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range schemas {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(WithSchema(ctx, name), q.ForSchema(name)); err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveSchema возвращает схему билдера: явную из ForSchema или из контекста
+func (qb *Builder) resolveSchema() string {
+	if qb.schema != "" {
+		return qb.schema
+	}
+	s := qb.queryBuilder.schemas
+	if s == nil {
+		return ""
+	}
+	// В транзакции с search_path уточнение не требуется
+	if s.mode == SchemaSearchPath && qb.tx != nil && qb.queryBuilder.driverName == "postgres" {
+		return ""
+	}
+	schema, _ := s.resolve(qb.ctx)
+	return schema
+}
+
+// checkSchema проверяет имя схемы перед выполнением запроса
+func (qb *Builder) checkSchema() error {
+	if schema := qb.resolveSchema(); schema != "" && !schemaNameRe.MatchString(schema) {
+		return fmt.Errorf("%w: %q", ErrInvalidSchema, schema)
+	}
+	return nil
+}
+
+// table возвращает имя основной таблицы с учетом схемы
+func (qb *Builder) table() string {
+	return qb.qualify(qb.tableName)
+}
+
+// qualify добавляет схему к выражению таблицы, если оно еще не уточнено
+func (qb *Builder) qualify(expr string) string {
+	schema := qb.resolveSchema()
+	if schema == "" || !schemaNameRe.MatchString(schema) {
+		return expr
+	}
+	table, _ := splitTableAlias(expr)
+	if strings.ContainsAny(table, ".(") {
+		return expr
+	}
+	if s := qb.queryBuilder.schemas; s != nil && s.shared[normalizeTable(table)] {
+		return expr
+	}
+	return schema + "." + strings.TrimLeft(expr, " ")
+}
+
+// setSearchPath переключает search_path транзакции Postgres на схему из контекста
+func (q *QueryBuilder) setSearchPath(ctx context.Context, t *Transaction) error {
+	if q.schemas == nil || q.schemas.mode != SchemaSearchPath || q.driverName != "postgres" {
+		return nil
+	}
+	schema, ok := q.schemas.resolve(ctx)
+	if !ok {
+		return nil
+	}
+	if !schemaNameRe.MatchString(schema) {
+		return fmt.Errorf("%w: %q", ErrInvalidSchema, schema)
+	}
+	return t.Raw("SET LOCAL search_path TO " + schema + ", public").Exec()
+}
@@ -0,0 +1,119 @@
+package qb
+
+// SoftDeleteScope имя глобального скоупа мягкого удаления
+const SoftDeleteScope = "soft_delete"
+
+// Scope именованная настройка билдера, например:
+//
+//	var Active qb.Scope = func(b *qb.Builder) { b.Where("active = ?", true) }
+type Scope func(*Builder)
+
+// globalScope скоуп, применяемый ко всем запросам таблицы
+type globalScope struct {
+	name  string
+	scope Scope
+}
+
+// AddGlobalScope регистрирует скоуп, который автоматически добавляет условия
+// ко всем запросам таблицы. Скоуп с тем же именем заменяется
+func (q *QueryBuilder) AddGlobalScope(table string, name string, scope Scope) {
+	if q.globalScopes == nil {
+		q.globalScopes = make(map[string][]globalScope)
+	}
+	table = normalizeTable(table)
+	for i, s := range q.globalScopes[table] {
+		if s.name == name {
+			q.globalScopes[table][i].scope = scope
+			return
+		}
+	}
+	q.globalScopes[table] = append(q.globalScopes[table], globalScope{name: name, scope: scope})
+}
+
+// Scope применяет локальные скоупы к билдеру
+func (qb *Builder) Scope(scopes ...Scope) *Builder {
+	for _, scope := range scopes {
+		scope(qb)
+	}
+	return qb
+}
+
+// WithoutScope отключает глобальные скоупы с указанными именами
+func (qb *Builder) WithoutScope(names ...string) *Builder {
+	if qb.withoutScopes == nil {
+		qb.withoutScopes = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		qb.withoutScopes[name] = true
+	}
+	return qb
+}
+
+// WithoutGlobalScopes отключает все глобальные скоупы
+func (qb *Builder) WithoutGlobalScopes() *Builder {
+	qb.withoutGlobalScopes = true
+	return qb
+}
+
+// hasGlobalScope проверяет, зарегистрирован ли для таблицы скоуп
+func (qb *Builder) hasGlobalScope(name string) bool {
+	for _, s := range qb.queryBuilder.globalScopes[normalizeTable(qb.tableName)] {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// globalScopeConditions собирает условия включенных глобальных скоупов таблицы
+func (qb *Builder) globalScopeConditions() ([]string, []any) {
+	if qb.withoutGlobalScopes {
+		return nil, nil
+	}
+	var clauses []string
+	var args []any
+	for _, s := range qb.queryBuilder.globalScopes[normalizeTable(qb.tableName)] {
+		if qb.withoutScopes[s.name] {
+			continue
+		}
+		scoped := &Builder{
+			tableName:     qb.tableName,
+			alias:         qb.alias,
+			joins:         qb.joins,
+			ctx:           qb.ctx,
+			tx:            qb.tx,
+			schema:        qb.schema,
+			withoutTenant: qb.withoutTenant,
+			queryBuilder:  qb.queryBuilder,
+			onlyTrashed:   qb.onlyTrashed,
+		}
+		s.scope(scoped)
+		clause, scopeArgs := scoped.buildConditions(scoped.conditions)
+		if clause == "" {
+			continue
+		}
+		clauses = append(clauses, "("+clause+")")
+		args = append(args, scopeArgs...)
+	}
+	return clauses, args
+}
+
+// tableRef возвращает префикс колонок основной таблицы, если в запросе есть соединения
+func (qb *Builder) tableRef() string {
+	if len(qb.joins) == 0 {
+		return ""
+	}
+	if qb.alias != "" {
+		return qb.alias
+	}
+	return qb.tableName
+}
+
+// QualifiedColumn добавляет к колонке префикс основной таблицы, если в запросе есть соединения.
+// Используется в глобальных скоупах, чтобы условия не становились неоднозначными
+func (qb *Builder) QualifiedColumn(column string) string {
+	if ref := qb.tableRef(); ref != "" {
+		return ref + "." + column
+	}
+	return column
+}
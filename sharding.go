@@ -0,0 +1,485 @@
+package qb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCrossShardWrite запись без ключа шарда затронула бы все шарды
+	ErrCrossShardWrite = errors.New("qb: cross-shard write is not allowed, use Shard(key) or AllowCrossShard()")
+	// ErrUnknownShard резолвер вернул шард, которого нет в карте
+	ErrUnknownShard = errors.New("qb: unknown shard")
+)
+
+// ShardResolver определяет имя шарда по ключу
+type ShardResolver func(key any) (string, error)
+
+// HashShardResolver распределяет ключи по шардам по хешу
+func HashShardResolver(shards ...string) ShardResolver {
+	return func(key any) (string, error) {
+		if len(shards) == 0 {
+			return "", ErrUnknownShard
+		}
+		h := fnv.New32a()
+		fmt.Fprint(h, key)
+		return shards[h.Sum32()%uint32(len(shards))], nil
+	}
+}
+
+// ShardRange диапазон целочисленных ключей [From, To) шарда
+type ShardRange struct {
+	From  int64
+	To    int64
+	Shard string
+}
+
+// RangeShardResolver распределяет целочисленные ключи по диапазонам
+func RangeShardResolver(ranges ...ShardRange) ShardResolver {
+	return func(key any) (string, error) {
+		v := reflect.ValueOf(key)
+		var n int64
+		switch {
+		case v.CanInt():
+			n = v.Int()
+		case v.CanUint():
+			n = int64(v.Uint())
+		default:
+			return "", fmt.Errorf("qb: range shard key must be an integer, got %T", key)
+		}
+		for _, r := range ranges {
+			if n >= r.From && n < r.To {
+				return r.Shard, nil
+			}
+		}
+		return "", fmt.Errorf("%w for key %d", ErrUnknownShard, n)
+	}
+}
+
+// ShardedQueryBuilder маршрутизирует запросы по шардам
+type ShardedQueryBuilder struct {
+	shards   map[string]QueryBuilderInterface
+	names    []string
+	resolver ShardResolver
+}
+
+// NewSharded создает билдер поверх карты шардов и резолвера ключей
+func NewSharded(shards map[string]QueryBuilderInterface, resolver ShardResolver) *ShardedQueryBuilder {
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &ShardedQueryBuilder{shards: shards, names: names, resolver: resolver}
+}
+
+// Shard возвращает билдер шарда по ключу
+func (s *ShardedQueryBuilder) Shard(key any) (QueryBuilderInterface, error) {
+	name, err := s.resolver(key)
+	if err != nil {
+		return nil, err
+	}
+	shard, ok := s.shards[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownShard, name)
+	}
+	return shard, nil
+}
+
+// From начинает запрос к таблице на всех шардах
+func (s *ShardedQueryBuilder) From(table string) *ShardedBuilder {
+	return &ShardedBuilder{sharded: s, table: table, ctx: context.TODO()}
+}
+
+// shardOrder колонка сортировки для слияния результатов
+type shardOrder struct {
+	column string
+	desc   bool
+}
+
+// ShardedBuilder запрос к таблице, распределенной по шардам
+type ShardedBuilder struct {
+	sharded    *ShardedQueryBuilder
+	table      string
+	ctx        context.Context
+	scopes     []func(*Builder)
+	orders     []shardOrder
+	limit      int
+	offset     int
+	crossShard bool
+}
+
+// Context задает контекст выполнения запросов
+func (sb *ShardedBuilder) Context(ctx context.Context) *ShardedBuilder {
+	sb.ctx = ctx
+	return sb
+}
+
+// Apply добавляет произвольную настройку билдера каждого шарда
+func (sb *ShardedBuilder) Apply(fn func(*Builder)) *ShardedBuilder {
+	sb.scopes = append(sb.scopes, fn)
+	return sb
+}
+
+// Select задает колонки выборки
+func (sb *ShardedBuilder) Select(columns ...string) *ShardedBuilder {
+	return sb.Apply(func(b *Builder) { b.Select(columns...) })
+}
+
+// Where добавляет условие AND
+func (sb *ShardedBuilder) Where(condition string, args ...any) *ShardedBuilder {
+	return sb.Apply(func(b *Builder) { b.Where(condition, args...) })
+}
+
+// OrderBy добавляет сортировку, которая применяется и при слиянии результатов шардов
+func (sb *ShardedBuilder) OrderBy(column string, direction string) *ShardedBuilder {
+	sb.orders = append(sb.orders, shardOrder{column: column, desc: strings.EqualFold(direction, "DESC")})
+	return sb.Apply(func(b *Builder) { b.OrderBy(column, direction) })
+}
+
+// Limit ограничивает общее число записей после слияния
+func (sb *ShardedBuilder) Limit(limit int) *ShardedBuilder {
+	sb.limit = limit
+	return sb
+}
+
+// Offset задает смещение после слияния
+func (sb *ShardedBuilder) Offset(offset int) *ShardedBuilder {
+	sb.offset = offset
+	return sb
+}
+
+// AllowCrossShard разрешает запись сразу во все шарды
+func (sb *ShardedBuilder) AllowCrossShard() *ShardedBuilder {
+	sb.crossShard = true
+	return sb
+}
+
+// Shard возвращает билдер шарда по ключу с уже заданными условиями
+func (sb *ShardedBuilder) Shard(key any) (BuilderInterface, error) {
+	shard, err := sb.sharded.Shard(key)
+	if err != nil {
+		return nil, err
+	}
+	b := shard.From(sb.table).Context(sb.ctx)
+	for _, scope := range sb.scopes {
+		scope(b)
+	}
+	if sb.limit > 0 {
+		b.Limit(sb.limit)
+	}
+	if sb.offset > 0 {
+		b.Offset(sb.offset)
+	}
+	return b, nil
+}
+
+// Get выполняет запрос на всех шардах параллельно и объединяет результаты в dest.
+// Сортировка и LIMIT/OFFSET применяются к объединенному результату
+func (sb *ShardedBuilder) Get(dest any) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("qb: sharded Get needs a pointer to a slice, got %T", dest)
+	}
+	sliceType := slice.Elem().Type()
+
+	results := make([]reflect.Value, len(sb.sharded.names))
+	err := sb.each(func(i int, b *Builder) error {
+		// Каждый шард возвращает первые offset+limit записей, окно выбирается после слияния
+		if sb.limit > 0 {
+			b.Limit(sb.limit + sb.offset)
+		}
+		part := reflect.New(sliceType)
+		if _, err := b.Get(part.Interface()); err != nil {
+			return err
+		}
+		results[i] = part.Elem()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	merged := reflect.MakeSlice(sliceType, 0, 0)
+	for _, part := range results {
+		merged = reflect.AppendSlice(merged, part)
+	}
+	if len(sb.orders) > 0 {
+		sort.SliceStable(merged.Interface(), func(i, j int) bool {
+			return sb.less(merged.Index(i), merged.Index(j))
+		})
+	}
+
+	start := min(sb.offset, merged.Len())
+	end := merged.Len()
+	if sb.limit > 0 {
+		end = min(start+sb.limit, end)
+	}
+	slice.Elem().Set(merged.Slice(start, end))
+	return nil
+}
+
+// Count возвращает количество записей на всех шардах
+func (sb *ShardedBuilder) Count() (int64, error) {
+	counts := make([]int64, len(sb.sharded.names))
+	err := sb.each(func(i int, b *Builder) error {
+		count, err := b.Count()
+		counts[i] = count
+		return err
+	})
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	return total, err
+}
+
+// Sum вычисляет сумму значений колонки на всех шардах
+func (sb *ShardedBuilder) Sum(column string) (float64, error) {
+	values, err := sb.aggregate("SUM", column)
+	var total float64
+	for _, v := range values {
+		total += v.Float64
+	}
+	return total, err
+}
+
+// Min находит минимальное значение колонки на всех шардах
+func (sb *ShardedBuilder) Min(column string) (float64, error) {
+	return sb.extreme("MIN", column, func(a, b float64) bool { return a < b })
+}
+
+// Max находит максимальное значение колонки на всех шардах
+func (sb *ShardedBuilder) Max(column string) (float64, error) {
+	return sb.extreme("MAX", column, func(a, b float64) bool { return a > b })
+}
+
+// Avg вычисляет среднее по всем шардам как общую сумму, деленную на общее число значений.
+// Сумма и число значений шарда читаются одним запросом, чтобы относиться к одному состоянию
+func (sb *ShardedBuilder) Avg(column string) (float64, error) {
+	parts := make([]struct {
+		Sum   sql.NullFloat64 `db:"total"`
+		Count sql.NullFloat64 `db:"cnt"`
+	}, len(sb.sharded.names))
+	err := sb.each(func(i int, b *Builder) error {
+		head := fmt.Sprintf("SELECT SUM(%s) AS total, COUNT(%s) AS cnt FROM %s", column, column, b.table())
+		body, args := b.buildBodyQuery()
+		_, err := b.execGetContext(b.ctx, &parts[i], head+body, args...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var sum, count float64
+	for _, part := range parts {
+		sum += part.Sum.Float64
+		count += part.Count.Float64
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return sum / count, nil
+}
+
+// Update обновляет записи на всех шардах, если это разрешено через AllowCrossShard
+func (sb *ShardedBuilder) Update(data any, fields ...string) error {
+	return sb.write(func(b *Builder) error { return b.Update(data, fields...) })
+}
+
+// UpdateMap обновляет записи на всех шардах, если это разрешено через AllowCrossShard
+func (sb *ShardedBuilder) UpdateMap(data map[string]any) error {
+	return sb.write(func(b *Builder) error { return b.UpdateMap(data) })
+}
+
+// Delete удаляет записи на всех шардах, если это разрешено через AllowCrossShard
+func (sb *ShardedBuilder) Delete() error {
+	return sb.write(func(b *Builder) error { return b.Delete() })
+}
+
+func (sb *ShardedBuilder) write(fn func(*Builder) error) error {
+	if !sb.crossShard {
+		return ErrCrossShardWrite
+	}
+	return sb.each(func(_ int, b *Builder) error { return fn(b) })
+}
+
+// aggregate выполняет агрегатную функцию на каждом шарде
+func (sb *ShardedBuilder) aggregate(fn string, column string) ([]sql.NullFloat64, error) {
+	values := make([]sql.NullFloat64, len(sb.sharded.names))
+	err := sb.each(func(i int, b *Builder) error {
+		head := fmt.Sprintf("SELECT %s(%s) FROM %s", fn, column, b.table())
+		body, args := b.buildBodyQuery()
+		_, err := b.execGetContext(b.ctx, &values[i], head+body, args...)
+		return err
+	})
+	return values, err
+}
+
+// extreme выбирает минимум или максимум среди шардов, пропуская пустые
+func (sb *ShardedBuilder) extreme(fn string, column string, better func(a, b float64) bool) (float64, error) {
+	values, err := sb.aggregate(fn, column)
+	var result float64
+	found := false
+	for _, v := range values {
+		if v.Valid && (!found || better(v.Float64, result)) {
+			result, found = v.Float64, true
+		}
+	}
+	return result, err
+}
+
+// each выполняет fn для билдера каждого шарда параллельно.
+// При первой ошибке контекст остальных запросов отменяется
+func (sb *ShardedBuilder) each(fn func(i int, b *Builder) error) error {
+	ctx, cancel := context.WithCancel(sb.ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i, name := range sb.sharded.names {
+		b := sb.sharded.shards[name].From(sb.table).Context(ctx)
+		for _, scope := range sb.scopes {
+			scope(b)
+		}
+		wg.Add(1)
+		go func(i int, name string, b *Builder) {
+			defer wg.Done()
+			if err := fn(i, b); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("shard %s: %w", name, err)
+					cancel()
+				})
+			}
+		}(i, name, b)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// less сравнивает элементы объединенного результата по колонкам сортировки
+func (sb *ShardedBuilder) less(a, b reflect.Value) bool {
+	for _, order := range sb.orders {
+		column := order.column
+		if dot := strings.LastIndexByte(column, '.'); dot >= 0 {
+			column = column[dot+1:]
+		}
+		c := compareValues(columnValue(a, column), columnValue(b, column))
+		if c == 0 {
+			continue
+		}
+		if order.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// columnValue возвращает значение колонки из строки результата: map или структуры с тегами db
+func columnValue(row reflect.Value, column string) any {
+	for row.Kind() == reflect.Ptr || row.Kind() == reflect.Interface {
+		if row.IsNil() {
+			return nil
+		}
+		row = row.Elem()
+	}
+	switch row.Kind() {
+	case reflect.Map:
+		v := row.MapIndex(reflect.ValueOf(column))
+		if !v.IsValid() {
+			return nil
+		}
+		return v.Interface()
+	case reflect.Struct:
+		field, ok := structColumn(row, column)
+		if !ok {
+			return nil
+		}
+		return field.Interface()
+	default:
+		return row.Interface()
+	}
+}
+
+// compareValues сравнивает значения колонки; NULL меньше любого значения
+func compareValues(a, b any) int {
+	a, b = derefValue(a), derefValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ba, ok := a.([]byte); ok {
+		if bb, ok := b.([]byte); ok {
+			return bytes.Compare(ba, bb)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// derefValue раскрывает указатели и sql.Null* типы
+func derefValue(v any) any {
+	if valuer, ok := v.(interface{ Value() (any, error) }); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		return value
+	}
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
+
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int()), true
+	case rv.CanUint():
+		return float64(rv.Uint()), true
+	case rv.CanFloat():
+		return rv.Float(), true
+	}
+	return 0, false
+}
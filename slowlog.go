@@ -0,0 +1,176 @@
+package qb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowQueryOptions настройки журнала медленных запросов
+type SlowQueryOptions struct {
+	// Threshold длительность, начиная с которой запрос считается медленным
+	Threshold time.Duration
+	// Explain включает фоновый EXPLAIN медленных запросов
+	Explain bool
+	// ExplainInterval минимальный интервал между EXPLAIN одного отпечатка, по умолчанию минута
+	ExplainInterval time.Duration
+	// ExplainTimeout таймаут выполнения EXPLAIN, по умолчанию 5 секунд
+	ExplainTimeout time.Duration
+}
+
+// slowQueryLog журнал медленных запросов
+type slowQueryLog struct {
+	opts      SlowQueryOptions
+	mu        sync.Mutex
+	explained map[string]time.Time
+}
+
+// SetSlowQueryLog включает журнал медленных запросов.
+// Нулевой Threshold отключает журнал.
+func (q *QueryBuilder) SetSlowQueryLog(opts SlowQueryOptions) {
+	if opts.Threshold <= 0 {
+		q.slowLog = nil
+		return
+	}
+	if opts.ExplainInterval <= 0 {
+		opts.ExplainInterval = time.Minute
+	}
+	if opts.ExplainTimeout <= 0 {
+		opts.ExplainTimeout = 5 * time.Second
+	}
+	q.slowLog = &slowQueryLog{
+		opts:      opts,
+		explained: make(map[string]time.Time),
+	}
+}
+
+// logSlowQuery пишет предупреждение о медленном запросе
+// и при необходимости запускает фоновый EXPLAIN
+func (q *QueryBuilder) logSlowQuery(event QueryEvent) {
+	sl := q.slowLog
+	if sl == nil || q.logger == nil || event.Duration < sl.opts.Threshold {
+		return
+	}
+
+	fingerprint := Fingerprint(event.SQL)
+	attrs := []any{
+		slog.String("query", event.SQL),
+		slog.String("fingerprint", fingerprint),
+		slog.String("caller", queryCaller()),
+	}
+	if arg, ok := q.argsAttr(event.SQL, event.Args); ok {
+		attrs = append(attrs, arg)
+	}
+	attrs = append(attrs, slog.String("mc", event.Duration.String()))
+
+	if !sl.opts.Explain || !explainable(event.Operation) || !sl.allowExplain(fingerprint) {
+		q.logger.Warn("slow query", attrs...)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sl.opts.ExplainTimeout)
+		defer cancel()
+
+		plan, err := q.explain(ctx, event.SQL, event.Args)
+		if err != nil {
+			attrs = append(attrs, slog.String("explain_error", err.Error()))
+		} else {
+			attrs = append(attrs, slog.String("plan", plan))
+		}
+		q.logger.Warn("slow query", attrs...)
+	}()
+}
+
+// allowExplain ограничивает частоту EXPLAIN для одного отпечатка
+func (sl *slowQueryLog) allowExplain(fingerprint string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := sl.explained[fingerprint]; ok && now.Sub(last) < sl.opts.ExplainInterval {
+		return false
+	}
+	if len(sl.explained) >= 1024 {
+		for fp, last := range sl.explained {
+			if now.Sub(last) >= sl.opts.ExplainInterval {
+				delete(sl.explained, fp)
+			}
+		}
+	}
+	sl.explained[fingerprint] = now
+	return true
+}
+
+// explain выполняет EXPLAIN запроса напрямую, минуя хуки и метрики
+func (q *QueryBuilder) explain(ctx context.Context, query string, args []any) (string, error) {
+	prefix := "EXPLAIN "
+	if strings.HasPrefix(q.driverName, "sqlite") {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+
+	rows, err := q.db.QueryxContext(ctx, prefix+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return "", err
+		}
+		parts := make([]string, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			parts[i] = fmt.Sprint(v)
+		}
+		lines = append(lines, strings.Join(parts, " | "))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) > 1 {
+		lines = append([]string{strings.Join(columns, " | ")}, lines...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// explainable проверяет, поддерживает ли операция EXPLAIN без выполнения
+func explainable(operation string) bool {
+	switch operation {
+	case "select", "update", "delete", "insert":
+		return true
+	}
+	return false
+}
+
+var packagePath = reflect.TypeOf(QueryBuilder{}).PkgPath()
+
+// queryCaller возвращает file:line первого вызова за пределами пакета
+func queryCaller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePath+".") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package qb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrRestoreConflict восстановление записи нарушит уникальный ключ
+var ErrRestoreConflict = errors.New("qb: restore conflicts with an existing row")
+
+// SoftDeleteType тип колонки мягкого удаления
+type SoftDeleteType int
+
+const (
+	// SoftDeleteTimestamp время удаления в UTC, NULL у живых записей
+	SoftDeleteTimestamp SoftDeleteType = iota
+	// SoftDeleteBoolean флаг удаления, false у живых записей
+	SoftDeleteBoolean
+)
+
+// SoftDeleteCascade дочерняя таблица, записи которой удаляются вместе с родительскими
+type SoftDeleteCascade struct {
+	Table string
+	// ForeignKey колонка дочерней таблицы со ссылкой на ключ родителя
+	ForeignKey string
+}
+
+// SoftDeleteOptions настройки мягкого удаления таблицы
+type SoftDeleteOptions struct {
+	// Column колонка мягкого удаления, по умолчанию deleted_at
+	Column string
+	Type   SoftDeleteType
+	// DeletedByColumn колонка для пользователя из WithAudit или AuditMeta, пусто — не заполняется
+	DeletedByColumn string
+	// Key первичный ключ, по умолчанию id
+	Key string
+	// Cascade дочерние таблицы, удаляемые в той же транзакции
+	Cascade []SoftDeleteCascade
+	// UniqueKeys наборы колонок, по которым Restore проверяет конфликты с живыми записями
+	UniqueKeys [][]string
+}
+
+// SoftDelete добавляет поддержку мягкого удаления
+type SoftDelete struct {
+	DeletedAt *time.Time `db:"deleted_at"`
+}
+
+// SetSoftDeleteOptions включает мягкое удаление таблицы с указанными настройками
+func (q *QueryBuilder) SetSoftDeleteOptions(table string, opts SoftDeleteOptions) {
+	if q.softDeletes == nil {
+		q.softDeletes = make(map[string]SoftDeleteOptions)
+	}
+	q.softDeletes[normalizeTable(table)] = opts.withDefaults()
+	q.AddGlobalScope(table, SoftDeleteScope, func(b *Builder) {
+		b.softDeleteOptions().trashedCondition(b, b.onlyTrashed)
+	})
+}
+
+// UseSoftDeletes исключает удаленные записи (deleted_at IS NOT NULL)
+// из всех запросов к таблицам, см. WithTrashed и OnlyTrashed
+func (q *QueryBuilder) UseSoftDeletes(tables ...string) {
+	for _, table := range tables {
+		q.SetSoftDeleteOptions(table, SoftDeleteOptions{})
+	}
+}
+
+func (o SoftDeleteOptions) withDefaults() SoftDeleteOptions {
+	if o.Column == "" {
+		o.Column = "deleted_at"
+	}
+	if o.Key == "" {
+		o.Key = "id"
+	}
+	return o
+}
+
+// trashedCondition добавляет условие на удаленные или живые записи
+func (o SoftDeleteOptions) trashedCondition(b *Builder, trashed bool) {
+	column := b.QualifiedColumn(o.Column)
+	switch {
+	case o.Type == SoftDeleteBoolean:
+		b.Where(column+" = ?", trashed)
+	case trashed:
+		b.WhereNotNull(column)
+	default:
+		b.WhereNull(column)
+	}
+}
+
+// softDeleteOptions возвращает настройки мягкого удаления таблицы билдера
+func (qb *Builder) softDeleteOptions() SoftDeleteOptions {
+	if opts, ok := qb.queryBuilder.softDeletes[normalizeTable(qb.tableName)]; ok {
+		return opts
+	}
+	return SoftDeleteOptions{}.withDefaults()
+}
+
+// WithTrashed включает удаленные записи в выборку
+func (qb *Builder) WithTrashed() *Builder {
+	qb.onlyTrashed = false
+	return qb.WithoutScope(SoftDeleteScope)
+}
+
+// OnlyTrashed выбирает только удаленные записи
+func (qb *Builder) OnlyTrashed() *Builder {
+	if !qb.hasGlobalScope(SoftDeleteScope) {
+		qb.softDeleteOptions().trashedCondition(qb, true)
+		return qb
+	}
+	qb.onlyTrashed = true
+	delete(qb.withoutScopes, SoftDeleteScope)
+	return qb
+}
+
+// SoftDelete помечает записи как удаленные вместе с записями дочерних таблиц из Cascade
+func (qb *Builder) SoftDelete() error {
+	opts := qb.softDeleteOptions()
+	if len(opts.Cascade) == 0 {
+		return qb.softDelete(opts, nil)
+	}
+	return qb.inTransaction(func(b *Builder) error {
+		keys, err := b.Values(b.QualifiedColumn(opts.Key))
+		if err != nil {
+			return err
+		}
+		return b.softDelete(opts, keys)
+	})
+}
+
+// softDelete помечает записи как удаленные и каскадно удаляет дочерние по ключам родителей
+func (qb *Builder) softDelete(opts SoftDeleteOptions, keys []any) error {
+	data := map[string]any{opts.Column: time.Now().UTC()}
+	if opts.Type == SoftDeleteBoolean {
+		data[opts.Column] = true
+	}
+	if opts.DeletedByColumn != "" {
+		if userID := qb.auditUser(); userID != nil {
+			data[opts.DeletedByColumn] = userID
+		}
+	}
+	if err := qb.updateMap(data, AuditSoftDelete); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, cascade := range opts.Cascade {
+		if err := qb.related(cascade.Table).WhereIn(cascade.ForeignKey, keys...).SoftDelete(); err != nil {
+			return fmt.Errorf("cascade %s: %w", cascade.Table, err)
+		}
+	}
+	return nil
+}
+
+// Restore восстанавливает удаленные записи.
+// При заданных UniqueKeys возвращает ErrRestoreConflict, если живая запись уже заняла тот же ключ
+func (qb *Builder) Restore() error {
+	opts := qb.softDeleteOptions()
+	if qb.hasGlobalScope(SoftDeleteScope) {
+		qb.OnlyTrashed()
+	}
+	if len(opts.UniqueKeys) > 0 {
+		if err := qb.checkRestoreConflicts(opts); err != nil {
+			return err
+		}
+	}
+	data := map[string]any{opts.Column: nil}
+	if opts.Type == SoftDeleteBoolean {
+		data[opts.Column] = false
+	}
+	if opts.DeletedByColumn != "" {
+		data[opts.DeletedByColumn] = nil
+	}
+	return qb.updateMap(data, AuditRestore)
+}
+
+// checkRestoreConflicts ищет живые записи с теми же уникальными ключами, что и восстанавливаемые
+func (qb *Builder) checkRestoreConflicts(opts SoftDeleteOptions) error {
+	rows, err := qb.auditRowsWhere()
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for _, columns := range opts.UniqueKeys {
+			live := qb.related(qb.tableName).Where(opts.Key+" <> ?", row[opts.Key])
+			values := make([]any, len(columns))
+			for i, column := range columns {
+				values[i] = row[column]
+				live.Where(column+" = ?", row[column])
+			}
+			exists, err := live.Exists()
+			if err != nil {
+				return err
+			}
+			if exists {
+				return fmt.Errorf("%w: %s %s = %v", ErrRestoreConflict, qb.tableName, strings.Join(columns, ", "), values)
+			}
+		}
+	}
+	return nil
+}
+
+// ForceDelete удаляет записи физически, включая мягко удаленные
+func (qb *Builder) ForceDelete() error {
+	return qb.WithTrashed().forceDelete()
+}
+
+// PurgeTrashed физически удаляет записи, мягко удаленные раньше чем olderThan назад
+func (qb *Builder) PurgeTrashed(olderThan time.Duration) error {
+	opts := qb.softDeleteOptions()
+	if opts.Type != SoftDeleteTimestamp {
+		return fmt.Errorf("qb: purge of %s needs a timestamp soft delete column", qb.tableName)
+	}
+	return qb.WithTrashed().
+		Where(qb.QualifiedColumn(opts.Column)+" < ?", time.Now().UTC().Add(-olderThan)).
+		forceDelete()
+}
+
+// related создает билдер другой таблицы с тем же исполнителем, контекстом и схемой
+func (qb *Builder) related(table string) *Builder {
+	return &Builder{
+		tableName:     table,
+		db:            qb.db,
+		queryBuilder:  qb.queryBuilder,
+		ctx:           qb.ctx,
+		tx:            qb.tx,
+		usePrimary:    qb.usePrimary,
+		withoutTenant: qb.withoutTenant,
+		schema:        qb.schema,
+		auditEnabled:  qb.auditEnabled,
+		auditUserID:   qb.auditUserID,
+		metrics:       qb.metrics,
+	}
+}
+
+// inTransaction выполняет fn с билдером в транзакции: текущей или новой
+func (qb *Builder) inTransaction(fn func(b *Builder) error) error {
+	if qb.tx != nil {
+		return fn(qb)
+	}
+	return qb.queryBuilder.TransactionContext(qb.ctx, func(tx *Transaction) error {
+		bound := *qb
+		bound.db = tx.Tx
+		bound.tx = tx
+		return fn(&bound)
+	})
+}
@@ -0,0 +1,203 @@
+package qb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultTenantColumn колонка арендатора по умолчанию
+const DefaultTenantColumn = "tenant_id"
+
+// ErrNoTenant запрос к таблице арендатора без арендатора в контексте
+var ErrNoTenant = errors.New("qb: no tenant in context")
+
+// TenantOptions настройки режима арендаторов
+type TenantOptions struct {
+	// Column колонка арендатора, по умолчанию DefaultTenantColumn
+	Column string
+	// Tables таблицы, содержащие колонку арендатора
+	Tables []string
+}
+
+// tenantMode включенный режим арендаторов
+type tenantMode struct {
+	column string
+	tables map[string]bool
+}
+
+type tenantKey struct{}
+
+type tenantBypassKey struct{}
+
+// SetTenantMode включает автоматическую фильтрацию таблиц арендатора
+// по арендатору из контекста, см. WithTenant
+func (q *QueryBuilder) SetTenantMode(opts TenantOptions) {
+	if opts.Column == "" {
+		opts.Column = DefaultTenantColumn
+	}
+	tables := make(map[string]bool, len(opts.Tables))
+	for _, table := range opts.Tables {
+		tables[normalizeTable(table)] = true
+	}
+	q.tenant = &tenantMode{column: opts.Column, tables: tables}
+}
+
+// WithTenant добавляет арендатора в контекст
+func WithTenant(ctx context.Context, tenantID any) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext возвращает арендатора из контекста
+func TenantFromContext(ctx context.Context) (any, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenantID := ctx.Value(tenantKey{})
+	return tenantID, tenantID != nil
+}
+
+// WithoutTenantScope отключает фильтрацию по арендатору для запросов с этим контекстом.
+// Предназначен для административных задач
+func WithoutTenantScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantBypassKey{}, true)
+}
+
+// WithoutTenant отключает фильтрацию по арендатору для билдера
+func (qb *Builder) WithoutTenant() *Builder {
+	qb.withoutTenant = true
+	return qb
+}
+
+// tenantActive проверяет, применяется ли режим арендаторов к билдеру
+func (qb *Builder) tenantActive() (*tenantMode, bool) {
+	t := qb.queryBuilder.tenant
+	if t == nil || qb.withoutTenant {
+		return nil, false
+	}
+	if qb.ctx != nil {
+		if bypass, _ := qb.ctx.Value(tenantBypassKey{}).(bool); bypass {
+			return nil, false
+		}
+	}
+	return t, true
+}
+
+// tenantCondition возвращает условие по арендатору для таблицы, если она принадлежит арендатору.
+// ref задает префикс колонки, пустой — без префикса
+func (qb *Builder) tenantCondition(table string, ref string) (string, []any, bool) {
+	t, ok := qb.tenantActive()
+	if !ok || !t.tables[normalizeTable(table)] {
+		return "", nil, false
+	}
+	tenantID, ok := TenantFromContext(qb.ctx)
+	if !ok {
+		return "", nil, false
+	}
+	column := t.column
+	if ref != "" {
+		column = ref + "." + column
+	}
+	return column + " = ?", []any{tenantID}, true
+}
+
+// checkTenant возвращает ErrNoTenant, если запрос или его подзапросы
+// затрагивают таблицу арендатора без арендатора
+func (qb *Builder) checkTenant() error {
+	t, ok := qb.tenantActive()
+	if !ok {
+		return qb.checkSubQueryTenant()
+	}
+	if _, ok := TenantFromContext(qb.ctx); ok {
+		return qb.checkSubQueryTenant()
+	}
+	tables := []string{qb.tableName}
+	for _, join := range qb.joins {
+		table, _ := splitTableAlias(join.tableName)
+		tables = append(tables, table)
+	}
+	for _, table := range tables {
+		if t.tables[normalizeTable(table)] {
+			return fmt.Errorf("%w for table %s", ErrNoTenant, table)
+		}
+	}
+	return qb.checkSubQueryTenant()
+}
+
+// checkSubQueryTenant проверяет арендатора подзапросов в контексте билдера
+func (qb *Builder) checkSubQueryTenant() error {
+	for _, sub := range qb.subQueries() {
+		if err := sub.nestedIn(qb).checkTenant(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutTenantColumn убирает колонку арендатора из SET обновления
+func (qb *Builder) withoutTenantColumn(columns []string, values []any) ([]string, []any) {
+	var keptColumns []string
+	var keptValues []any
+	for i, column := range columns {
+		if qb.isTenantColumn(column) {
+			continue
+		}
+		keptColumns = append(keptColumns, column)
+		keptValues = append(keptValues, values[i])
+	}
+	return keptColumns, keptValues
+}
+
+// isTenantColumn проверяет, является ли колонка колонкой арендатора таблицы билдера.
+// Такие колонки не обновляются, чтобы запись нельзя было перенести к другому арендатору
+func (qb *Builder) isTenantColumn(column string) bool {
+	t, ok := qb.tenantActive()
+	return ok && column == t.column && t.tables[normalizeTable(qb.tableName)]
+}
+
+// tenantInsert возвращает колонку и значение арендатора для вставки
+func (qb *Builder) tenantInsert() (string, any, bool) {
+	t, ok := qb.tenantActive()
+	if !ok || !t.tables[normalizeTable(qb.tableName)] {
+		return "", nil, false
+	}
+	tenantID, ok := TenantFromContext(qb.ctx)
+	if !ok {
+		return "", nil, false
+	}
+	return t.column, tenantID, true
+}
+
+// tenantRecords возвращает копии записей с проставленным арендатором
+func (qb *Builder) tenantRecords(records ...map[string]any) []map[string]any {
+	column, tenantID, ok := qb.tenantInsert()
+	if !ok {
+		return records
+	}
+	result := make([]map[string]any, len(records))
+	for i, record := range records {
+		row := make(map[string]any, len(record)+1)
+		for k, v := range record {
+			row[k] = v
+		}
+		row[column] = tenantID
+		result[i] = row
+	}
+	return result
+}
+
+// splitTableAlias разделяет выражение таблицы на имя и ссылку для колонок
+func splitTableAlias(expr string) (table string, ref string) {
+	fields := strings.Fields(expr)
+	switch {
+	case len(fields) == 0:
+		return "", ""
+	case len(fields) >= 3 && strings.EqualFold(fields[1], "AS"):
+		return fields[0], fields[2]
+	case len(fields) >= 2:
+		return fields[0], fields[1]
+	default:
+		return fields[0], fields[0]
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditRow struct {
+	RecordID *string `db:"record_id"`
+	Action   string  `db:"action"`
+	OldData  *string `db:"old_data"`
+	NewData  *string `db:"new_data"`
+}
+
+func audits(t *testing.T, q qb.QueryBuilderInterface, table string) []auditRow {
+	t.Helper()
+	var rows []auditRow
+	_, err := q.From("audits").Select("record_id", "action", "old_data", "new_data").Where("table_name = ?", table).OrderBy("id", "ASC").Get(&rows)
+	require.NoError(t, err)
+	return rows
+}
+
+func recordIDs(rows []auditRow) []string {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		if row.RecordID != nil {
+			ids[i] = *row.RecordID
+		}
+	}
+	return ids
+}
+
+func TestAuditUpdateRecordsBeforeAndAfter(t *testing.T) {
+	q := newQB(t, usersTable, auditsTable)
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+
+	err = q.From("users").WithAudit(7).Where("id = ?", 1).UpdateMap(map[string]any{"name": "bob"})
+	require.NoError(t, err)
+
+	rows := audits(t, q, "users")
+	require.Len(t, rows, 1)
+	assert.Equal(t, qb.AuditUpdate, rows[0].Action)
+	assert.Equal(t, []string{"1"}, recordIDs(rows))
+	assert.Contains(t, *rows[0].OldData, `"ann"`)
+	assert.Contains(t, *rows[0].NewData, `"bob"`)
+}
+
+func TestAuditBulkInsertRecordsIDs(t *testing.T) {
+	q := newQB(t, usersTable, auditsTable)
+	err := q.From("users").WithAudit(nil).BulkInsert([]map[string]any{
+		{"name": "a"}, {"name": "b"}, {"name": "c"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1", "2", "3"}, recordIDs(audits(t, q, "users")))
+}
+
+func TestAuditCreateUsesPrimaryKeyColumn(t *testing.T) {
+	type account struct {
+		UID  int64  `db:"uid,pk,autoincrement"`
+		Name string `db:"name"`
+	}
+	q := newQB(t, `CREATE TABLE accounts (uid INTEGER PRIMARY KEY, name TEXT)`, auditsTable)
+
+	_, err := q.From("accounts").WithAudit(nil).Create(&account{Name: "a"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1"}, recordIDs(audits(t, q, "accounts")))
+}
+
+func TestAuditUsesConfiguredPrimaryKey(t *testing.T) {
+	type account struct {
+		UID  int64  `db:"uid,pk,autoincrement"`
+		Name string `db:"name"`
+	}
+	q := newQB(t, `CREATE TABLE accounts (uid INTEGER PRIMARY KEY, name TEXT)`, auditsTable)
+	q.SetPrimaryKey("accounts", "uid")
+
+	_, err := q.From("accounts").WithAudit(nil).CreateMap(map[string]any{"name": "a"})
+	require.NoError(t, err)
+	require.NoError(t, q.From("accounts").WithAudit(nil).Where("uid = ?", 1).UpdateMap(map[string]any{"name": "b"}))
+	require.NoError(t, q.From("accounts").WithAudit(nil).Where("uid = ?", 1).Update(&account{Name: "c"}, "name"))
+	require.NoError(t, q.From("accounts").WithAudit(nil).Where("uid = ?", 1).Delete())
+
+	rows := audits(t, q, "accounts")
+	assert.Equal(t, []string{"1", "1", "1", "1"}, recordIDs(rows))
+	require.NotNil(t, rows[1].NewData)
+	assert.Contains(t, *rows[1].NewData, `"b"`)
+	require.NotNil(t, rows[2].NewData)
+	assert.Contains(t, *rows[2].NewData, `"c"`)
+	assert.Equal(t, qb.AuditDelete, rows[3].Action)
+}
+
+func TestAuditBatchInsertRecordsIDs(t *testing.T) {
+	q := newQB(t, usersTable, auditsTable)
+	err := q.From("users").WithAudit(nil).BatchInsert([]map[string]any{{"name": "a"}, {"name": "b"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1", "2"}, recordIDs(audits(t, q, "users")))
+}
+
+func TestAuditFailureRollsBackWrite(t *testing.T) {
+	q := newQB(t, usersTable)
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+
+	// Таблицы аудита нет, поэтому запись аудита завершается ошибкой
+	err = q.From("users").WithAudit(nil).Where("id = ?", 1).UpdateMap(map[string]any{"name": "bob"})
+	require.Error(t, err)
+	_, err = q.From("users").WithAudit(nil).CreateMap(map[string]any{"name": "eve"})
+	require.Error(t, err)
+
+	var names []string
+	_, err = q.From("users").Select("name").Get(&names)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ann"}, names)
+}
+
+func TestAuditBulkUpdateStaysInTenant(t *testing.T) {
+	q := newQB(t, `CREATE TABLE docs (id INTEGER PRIMARY KEY, tenant_id INTEGER, title TEXT)`, auditsTable)
+	q.SetTenantMode(qb.TenantOptions{Tables: []string{"docs"}})
+	require.NoError(t, q.From("docs").Context(qb.WithTenant(context.Background(), 1)).
+		BatchInsert([]map[string]any{{"id": 1, "title": "mine"}}))
+	require.NoError(t, q.From("docs").Context(qb.WithTenant(context.Background(), 2)).
+		BatchInsert([]map[string]any{{"id": 2, "title": "theirs"}}))
+
+	err := q.From("docs").Context(qb.WithTenant(context.Background(), 1)).WithAudit(nil).
+		BulkUpdate([]map[string]any{{"id": 1, "title": "x"}, {"id": 2, "title": "x"}}, "id")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1"}, recordIDs(audits(t, q, "docs")))
+}
@@ -1 +1,42 @@
 package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+// openDB открывает чистую базу SQLite во временном каталоге теста и создает таблицы
+func openDB(t *testing.T, schema ...string) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range schema {
+		db.MustExec(stmt)
+	}
+	return db
+}
+
+// newQB возвращает QueryBuilder поверх чистой базы SQLite
+func newQB(t *testing.T, schema ...string) qb.QueryBuilderInterface {
+	t.Helper()
+	return qb.NewX("sqlite3", openDB(t, schema...))
+}
+
+const auditsTable = `CREATE TABLE audits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	table_name TEXT, record_id TEXT, action TEXT,
+	old_data TEXT, new_data TEXT, diff TEXT,
+	user_id TEXT, request_id TEXT, metadata TEXT, created_at DATETIME
+)`
+
+const usersTable = `CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL DEFAULT '',
+	email TEXT NOT NULL DEFAULT ''
+)`
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCacheRespectsTotalEntryBudget(t *testing.T) {
+	for _, maxEntries := range []int{1, 10, 20, 100} {
+		c := qb.NewMemoryCache(qb.MemoryCacheOptions{MaxEntries: maxEntries})
+		for i := 0; i < 1000; i++ {
+			c.Set(fmt.Sprintf("key-%d", i), i, time.Minute)
+		}
+		stats := c.Stats()
+		assert.LessOrEqual(t, stats.Entries, maxEntries, "MaxEntries=%d", maxEntries)
+		assert.Equal(t, int64(1000-stats.Entries), stats.Evictions)
+		c.Close()
+	}
+}
+
+func TestMemoryCacheRespectsTotalByteBudget(t *testing.T) {
+	c := qb.NewMemoryCache(qb.MemoryCacheOptions{MaxBytes: 1000})
+	defer c.Close()
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), "0123456789", time.Minute)
+	}
+	assert.LessOrEqual(t, c.Stats().Bytes, int64(1000))
+}
+
+func TestMemoryCacheLRUEviction(t *testing.T) {
+	c := qb.NewMemoryCache(qb.MemoryCacheOptions{MaxEntries: 2, Shards: 1})
+	defer c.Close()
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	c.Get("a")
+	c.Set("c", 3, time.Minute)
+
+	_, okA := c.Get("a")
+	_, okB := c.Get("b")
+	assert.True(t, okA)
+	assert.False(t, okB)
+}
+
+func TestMemoryCacheLFUEviction(t *testing.T) {
+	c := qb.NewMemoryCache(qb.MemoryCacheOptions{MaxEntries: 2, Shards: 1, Policy: qb.EvictLFU})
+	defer c.Close()
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	c.Get("b")
+	c.Get("b")
+	c.Get("a")
+	c.Set("c", 3, time.Minute)
+
+	_, okA := c.Get("a")
+	_, okB := c.Get("b")
+	assert.False(t, okA)
+	assert.True(t, okB)
+}
+
+func TestMemoryCacheExpiration(t *testing.T) {
+	c := qb.NewMemoryCache(qb.MemoryCacheOptions{})
+	defer c.Close()
+	c.Set("k", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get("k")
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), c.Stats().Expirations)
+}
+
+func TestMemoryCacheExpiredLockOwnerKeepsOffNewLock(t *testing.T) {
+	c := qb.NewMemoryCache(qb.MemoryCacheOptions{})
+	defer c.Close()
+
+	first, ok := c.TryLock("job", 10*time.Millisecond)
+	require.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	second, ok := c.TryLock("job", time.Minute)
+	require.True(t, ok)
+
+	c.Unlock("job", first)
+	_, ok = c.TryLock("job", time.Minute)
+	assert.False(t, ok)
+	c.Unlock("job", second)
+	_, ok = c.TryLock("job", time.Minute)
+	assert.True(t, ok)
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/antibomberman/qb"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRedis запускает miniredis и возвращает клиента к нему
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestRedisCacheClearKeepsForeignKeys(t *testing.T) {
+	mr, client := newRedis(t)
+	require.NoError(t, mr.Set("other-app:session", "keep"))
+	c := qb.NewRedisCache(client, qb.RedisCacheOptions{Prefix: "app:"})
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, c.SetContext(ctx, key, key, time.Minute))
+	}
+	assert.True(t, mr.Exists("app:a"))
+
+	require.NoError(t, c.ClearContext(ctx))
+
+	assert.False(t, mr.Exists("app:a"))
+	assert.False(t, mr.Exists("app:c"))
+	assert.True(t, mr.Exists("other-app:session"))
+}
+
+func TestRedisCacheTagsInvalidateGroup(t *testing.T) {
+	_, client := newRedis(t)
+	c := qb.NewRedisCache(client, qb.RedisCacheOptions{})
+	ctx := context.Background()
+	require.NoError(t, c.SetWithTags(ctx, "user:1", 1, time.Minute, "users"))
+	require.NoError(t, c.SetWithTags(ctx, "user:2", 2, time.Minute, "users", "admins"))
+	require.NoError(t, c.SetContext(ctx, "order:1", 1, time.Minute))
+
+	require.NoError(t, c.InvalidateTags(ctx, "users"))
+
+	_, ok := c.GetContext(ctx, "user:1")
+	assert.False(t, ok)
+	_, ok = c.GetContext(ctx, "user:2")
+	assert.False(t, ok)
+	_, ok = c.GetContext(ctx, "order:1")
+	assert.True(t, ok)
+}
+
+func TestRedisCacheTagSetOutlivesTaggedKeys(t *testing.T) {
+	mr, client := newRedis(t)
+	c := qb.NewRedisCache(client, qb.RedisCacheOptions{Prefix: "app:"})
+	ctx := context.Background()
+	require.NoError(t, c.SetWithTags(ctx, "short", 1, time.Second, "t"))
+	require.NoError(t, c.SetWithTags(ctx, "long", 1, time.Hour, "t"))
+
+	assert.Equal(t, time.Hour, mr.TTL("app:tag:t"))
+}
+
+func TestRedisCacheCodecsKeepTypes(t *testing.T) {
+	type profile struct {
+		ID    int64
+		Name  string
+		Since time.Time
+	}
+	gob.Register(profile{})
+	want := profile{ID: 1 << 60, Name: "ann", Since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	for name, codec := range map[string]qb.CacheCodec{"json": qb.JSONCodec{}, "gob": qb.GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			_, client := newRedis(t)
+			c := qb.NewRedisCache(client, qb.RedisCacheOptions{Codec: codec})
+			ctx := context.Background()
+			require.NoError(t, c.SetContext(ctx, "p", want, time.Minute))
+
+			var got profile
+			found, err := c.GetInto(ctx, "p", &got)
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestRedisCacheExpiration(t *testing.T) {
+	mr, client := newRedis(t)
+	c := qb.NewRedisCache(client, qb.RedisCacheOptions{})
+	c.Set("k", "v", time.Second)
+	mr.FastForward(2 * time.Second)
+
+	_, ok := c.Get("k")
+	assert.False(t, ok)
+}
+
+func TestRedisCacheLockIsExclusive(t *testing.T) {
+	_, client := newRedis(t)
+	a := qb.NewRedisCache(client, qb.RedisCacheOptions{})
+	b := qb.NewRedisCache(client, qb.RedisCacheOptions{})
+
+	token, ok := a.TryLock("job", time.Minute)
+	require.True(t, ok)
+	_, ok = b.TryLock("job", time.Minute)
+	assert.False(t, ok)
+	// Чужая блокировка не снимается
+	b.Unlock("job", "other")
+	_, ok = b.TryLock("job", time.Minute)
+	assert.False(t, ok)
+	a.Unlock("job", token)
+	_, ok = b.TryLock("job", time.Minute)
+	assert.True(t, ok)
+}
+
+func TestRedisCacheExpiredLockOwnerKeepsOffNewLock(t *testing.T) {
+	mr, client := newRedis(t)
+	c := qb.NewRedisCache(client, qb.RedisCacheOptions{})
+
+	first, ok := c.TryLock("job", time.Second)
+	require.True(t, ok)
+	mr.FastForward(2 * time.Second)
+	_, ok = c.TryLock("job", time.Minute)
+	require.True(t, ok)
+
+	// Прежний владелец не снимает блокировку, захваченную заново
+	c.Unlock("job", first)
+	_, ok = c.TryLock("job", time.Minute)
+	assert.False(t, ok)
+}
+
+func TestRedisCacheServesQueryCache(t *testing.T) {
+	_, client := newRedis(t)
+	q := newQB(t, usersTable)
+	q.(*qb.QueryBuilder).SetCache(qb.NewRedisCache(client, qb.RedisCacheOptions{}))
+	_, err := q.From("users").CreateMap(map[string]any{"name": "a"})
+	require.NoError(t, err)
+
+	var names []string
+	_, err = q.From("users").Select("name").Cache(time.Minute).Get(&names)
+	require.NoError(t, err)
+	require.NoError(t, q.From("users").Where("id = ?", 1).UpdateMap(map[string]any{"name": "b"}))
+
+	names = nil
+	_, err = q.From("users").Select("name").Cache(time.Minute).Get(&names)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names)
+}
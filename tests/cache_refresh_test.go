@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// slowSelects считает выборки и замедляет их, чтобы одновременные промахи кеша пересекались
+type slowSelects struct {
+	delay time.Duration
+	count atomic.Int64
+}
+
+func (h *slowSelects) BeforeQuery(ctx context.Context, event qb.QueryEvent) context.Context {
+	if event.Operation == "select" {
+		h.count.Add(1)
+		time.Sleep(h.delay)
+	}
+	return ctx
+}
+
+func (h *slowSelects) AfterQuery(context.Context, qb.QueryEvent) {}
+
+// readConcurrently читает пользователя 1 через кеш из n горутин одновременно
+func readConcurrently(t *testing.T, n int, queries ...qb.QueryBuilderInterface) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(q qb.QueryBuilderInterface) {
+			defer wg.Done()
+			var u user
+			found, err := q.From("users").Cache(time.Minute).Find(1, &u)
+			if err == nil && (!found || u.Name != "ann") {
+				err = context.Canceled
+			}
+			errs <- err
+		}(queries[i%len(queries)])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestQueryCacheSingleFlight(t *testing.T) {
+	q, _ := newCachedQB(t)
+	hook := &slowSelects{delay: 50 * time.Millisecond}
+	q.AddHook(hook)
+
+	readConcurrently(t, 20, q)
+	require.EqualValues(t, 1, hook.count.Load())
+}
+
+func TestQueryCacheRedisLockAcrossProcesses(t *testing.T) {
+	_, client := newRedis(t)
+	db := openDB(t, usersTable)
+	db.MustExec("INSERT INTO users (name) VALUES ('ann')")
+	hook := &slowSelects{delay: 100 * time.Millisecond}
+
+	// Два QueryBuilder с общим Redis — как два процесса
+	var queries []qb.QueryBuilderInterface
+	for i := 0; i < 2; i++ {
+		q := qb.NewX("sqlite3", db)
+		q.(*qb.QueryBuilder).SetCache(qb.NewRedisCache(client, qb.RedisCacheOptions{}))
+		q.AddHook(hook)
+		queries = append(queries, q)
+	}
+
+	readConcurrently(t, 10, queries...)
+	require.EqualValues(t, 1, hook.count.Load())
+}
+
+func TestQueryCacheStaleWhileRevalidate(t *testing.T) {
+	q, _ := newCachedQB(t)
+	q.SetCacheOptions(qb.CacheOptions{StaleWhileRevalidate: time.Hour})
+
+	read := func() string {
+		var u user
+		found, err := q.From("users").Cache(50*time.Millisecond).Find(1, &u)
+		require.NoError(t, err)
+		require.True(t, found)
+		return u.Name
+	}
+
+	require.Equal(t, "ann", read())
+	renameDirect(t, q, "bob")
+	time.Sleep(60 * time.Millisecond)
+	// Истекшее значение отдается сразу, обновление идет в фоне
+	require.Equal(t, "ann", read())
+	require.Eventually(t, func() bool { return read() == "bob" }, time.Second, 10*time.Millisecond)
+}
+
+func TestQueryCacheEarlyRefresh(t *testing.T) {
+	q, _ := newCachedQB(t)
+	q.SetCacheOptions(qb.CacheOptions{EarlyRefresh: 1e12})
+
+	require.Equal(t, "ann", cachedName(t, q))
+	renameDirect(t, q, "bob")
+	require.Equal(t, "ann", cachedName(t, q))
+	require.Eventually(t, func() bool { return cachedName(t, q) == "bob" }, time.Second, 10*time.Millisecond)
+}
+
+func TestQueryCacheLoadOutlivesFirstCaller(t *testing.T) {
+	q, _ := newCachedQB(t)
+	hook := &slowSelects{delay: 100 * time.Millisecond}
+	q.AddHook(hook)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	firstErr := make(chan error, 1)
+	go func() {
+		var u user
+		_, err := q.From("users").Context(ctx).Cache(time.Minute).Find(1, &u)
+		firstErr <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	// Отмена первого вызова не обрывает общий запрос для остальных
+	require.Equal(t, "ann", cachedName(t, q))
+	require.ErrorIs(t, <-firstErr, context.DeadlineExceeded)
+	require.EqualValues(t, 1, hook.count.Load())
+}
+
+// busyUsersLock кеш, в котором блокировку поколения users держит другой процесс
+type busyUsersLock struct {
+	qb.CacheInterface
+}
+
+func (busyUsersLock) TryLock(key string, _ time.Duration) (string, bool) {
+	return "token", key != "qb:tag:users:lock"
+}
+
+func (busyUsersLock) Unlock(string, string) {}
+
+func TestQueryCacheGenerationLockIsPerTable(t *testing.T) {
+	q := newQB(t, usersTable, ordersTable)
+	q.(*qb.QueryBuilder).SetCache(busyUsersLock{qb.NewCacheMemory()})
+	q.SetCacheOptions(qb.CacheOptions{LockTTL: 500 * time.Millisecond})
+
+	usersDone := make(chan error, 1)
+	go func() {
+		var users []user
+		_, err := q.From("users").Cache(time.Minute).Get(&users)
+		usersDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Ожидание блокировки users не задерживает чтение orders
+	start := time.Now()
+	var orders []struct {
+		ID int64 `db:"id"`
+	}
+	_, err := q.From("orders").Select("id").Cache(time.Minute).Get(&orders)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 200*time.Millisecond)
+	require.NoError(t, <-usersDone)
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// newCachedQB возвращает QueryBuilder с кешем в памяти и пользователем ann
+func newCachedQB(t *testing.T, schema ...string) (qb.QueryBuilderInterface, *qb.MetricsCollector) {
+	t.Helper()
+	q := newQB(t, append([]string{usersTable}, schema...)...)
+	q.(*qb.QueryBuilder).SetCache(qb.NewCacheMemory())
+	collector := qb.NewMetricsCollector()
+	q.SetMetrics(collector)
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+	return q, collector
+}
+
+// cachedName читает имя пользователя 1 через кеш запросов
+func cachedName(t *testing.T, q qb.QueryBuilderInterface) string {
+	t.Helper()
+	var u user
+	found, err := q.From("users").Cache(time.Minute).Find(1, &u)
+	require.NoError(t, err)
+	require.True(t, found)
+	return u.Name
+}
+
+// renameDirect меняет имя в обход билдера, кеш при этом не сбрасывается
+func renameDirect(t *testing.T, q qb.QueryBuilderInterface, name string) {
+	t.Helper()
+	_, err := q.GetDB().Exec("UPDATE users SET name = ? WHERE id = 1", name)
+	require.NoError(t, err)
+}
+
+func TestQueryCacheServesRepeatedReads(t *testing.T) {
+	q, collector := newCachedQB(t)
+
+	require.Equal(t, "ann", cachedName(t, q))
+	renameDirect(t, q, "bob")
+	require.Equal(t, "ann", cachedName(t, q))
+
+	hits, misses := collector.CacheStats()
+	require.EqualValues(t, 1, hits)
+	require.EqualValues(t, 1, misses)
+
+	// Другие аргументы — другой ключ
+	var u user
+	found, err := q.From("users").Cache(time.Minute).Where("name = ?", "bob").First(&u)
+	require.NoError(t, err)
+	require.True(t, found)
+}
+
+func TestQueryCacheInvalidatedByBuilderWrites(t *testing.T) {
+	q, _ := newCachedQB(t)
+
+	require.Equal(t, "ann", cachedName(t, q))
+	require.NoError(t, q.From("users").Where("id = ?", 1).UpdateMap(map[string]any{"name": "bob"}))
+	require.Equal(t, "bob", cachedName(t, q))
+
+	renameDirect(t, q, "eve")
+	require.Equal(t, "bob", cachedName(t, q))
+	require.NoError(t, q.Raw("UPDATE users SET email = ? WHERE id = 1", "e@x").Exec())
+	require.Equal(t, "eve", cachedName(t, q))
+}
+
+func TestQueryCacheTagsJoinedTables(t *testing.T) {
+	q, _ := newCachedQB(t, ordersTable)
+
+	countOrders := func() int {
+		var rows []struct {
+			Name string `db:"name"`
+		}
+		_, err := q.From("users").Select("users.name").
+			Join("orders", "orders.user_id = users.id").
+			Cache(time.Minute).Get(&rows)
+		require.NoError(t, err)
+		return len(rows)
+	}
+
+	require.Equal(t, 0, countOrders())
+	_, err := q.From("orders").CreateMap(map[string]any{"user_id": 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, countOrders())
+}
+
+func TestQueryCacheInvalidatedAfterCommit(t *testing.T) {
+	q, _ := newCachedQB(t)
+	require.Equal(t, "ann", cachedName(t, q))
+
+	tx, err := q.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.From("users").Where("id = ?", 1).UpdateMap(map[string]any{"name": "bob"}))
+	require.Equal(t, "ann", cachedName(t, q))
+	require.NoError(t, tx.Commit())
+	require.Equal(t, "bob", cachedName(t, q))
+
+	tx, err = q.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.From("users").Where("id = ?", 1).UpdateMap(map[string]any{"name": "eve"}))
+	require.NoError(t, tx.Rollback())
+	require.Equal(t, "bob", cachedName(t, q))
+}
+
+func TestRememberWithMemoryCache(t *testing.T) {
+	q, _ := newCachedQB(t)
+
+	var users []user
+	_, err := q.From("users").(*qb.Builder).Remember("all-users", time.Minute).GetCached(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	renameDirect(t, q, "bob")
+	users = nil
+	_, err = q.From("users").(*qb.Builder).Remember("all-users", time.Minute).GetCached(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "ann", users[0].Name)
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTiered(t *testing.T, client *redis.Client, l1TTL time.Duration) *qb.TieredCache {
+	t.Helper()
+	l1 := qb.NewMemoryCache(qb.MemoryCacheOptions{})
+	c := qb.NewTieredCache(l1, qb.NewRedisCache(client, qb.RedisCacheOptions{}), qb.TieredCacheOptions{L1TTL: l1TTL})
+	t.Cleanup(func() {
+		c.Close()
+		l1.Close()
+	})
+	return c
+}
+
+func TestTieredCacheL1DoesNotOutliveL2(t *testing.T) {
+	mr, client := newRedis(t)
+	l2 := qb.NewRedisCache(client, qb.RedisCacheOptions{})
+	require.NoError(t, l2.SetContext(context.Background(), "k", "v", 50*time.Millisecond))
+	c := newTiered(t, client, time.Hour)
+
+	value, ok := c.Get("k")
+	require.True(t, ok)
+	assert.Equal(t, "v", value)
+
+	time.Sleep(100 * time.Millisecond)
+	mr.FastForward(100 * time.Millisecond)
+	_, ok = c.Get("k")
+	assert.False(t, ok)
+}
+
+func TestTieredCacheFillsL1FromL2(t *testing.T) {
+	mr, client := newRedis(t)
+	c := newTiered(t, client, time.Hour)
+	c.Set("k", "v", time.Hour)
+
+	// Значение остается в L1, даже если L2 его потерял
+	mr.FlushAll()
+	value, ok := c.Get("k")
+	require.True(t, ok)
+	assert.Equal(t, "v", value)
+}
+
+func TestTieredCacheInvalidatesOtherNodes(t *testing.T) {
+	_, client := newRedis(t)
+	a := newTiered(t, client, time.Hour)
+	b := newTiered(t, client, time.Hour)
+	a.Set("k", "old", time.Hour)
+	value, ok := b.Get("k")
+	require.True(t, ok)
+	require.Equal(t, "old", value)
+
+	a.Set("k", "new", time.Hour)
+
+	assert.Eventually(t, func() bool {
+		value, _ := b.Get("k")
+		return value == "new"
+	}, time.Second, 5*time.Millisecond)
+}
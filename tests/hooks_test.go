@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+type spanKey struct{}
+
+// recordingHook запоминает события и проверяет, что контекст BeforeQuery доходит до AfterQuery
+type recordingHook struct {
+	mu     sync.Mutex
+	before []qb.QueryEvent
+	after  []qb.QueryEvent
+	spans  int
+}
+
+func (h *recordingHook) BeforeQuery(ctx context.Context, event qb.QueryEvent) context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.before = append(h.before, event)
+	return context.WithValue(ctx, spanKey{}, len(h.before))
+}
+
+func (h *recordingHook) AfterQuery(ctx context.Context, event qb.QueryEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := ctx.Value(spanKey{}).(int); ok {
+		h.spans++
+	}
+	h.after = append(h.after, event)
+}
+
+func TestHooksSeeEveryQuery(t *testing.T) {
+	q := newQB(t, usersTable)
+	hook := &recordingHook{}
+	q.AddHook(hook)
+
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+	var users []user
+	_, err = q.From("users").Where("name = ?", "ann").Get(&users)
+	require.NoError(t, err)
+	require.Error(t, q.Raw("SELECT * FROM missing").Query(&users))
+	require.NoError(t, q.Transaction(func(tx *qb.Transaction) error {
+		return tx.From("users").Where("name = ?", "ann").Delete()
+	}))
+
+	require.Len(t, hook.before, len(hook.after))
+	require.Equal(t, len(hook.after), hook.spans)
+
+	var ops []string
+	for _, event := range hook.after {
+		ops = append(ops, event.Operation)
+	}
+	require.Equal(t, []string{"insert", "select", "select", "begin", "delete", "commit"}, ops)
+
+	insert := hook.after[0]
+	require.Equal(t, "users", insert.Table)
+	require.Equal(t, []any{"ann"}, insert.Args)
+	require.EqualValues(t, 1, insert.Rows)
+	require.NoError(t, insert.Err)
+
+	get := hook.after[1]
+	require.Contains(t, get.SQL, "WHERE name = ?")
+	require.EqualValues(t, 1, get.Rows)
+	require.Positive(t, get.Duration)
+
+	require.Error(t, hook.after[2].Err)
+	require.Zero(t, hook.before[2].Duration)
+}
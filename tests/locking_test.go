@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const articlesTable = `CREATE TABLE articles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL DEFAULT '',
+	version INTEGER NOT NULL DEFAULT 1,
+	updated_at DATETIME
+)`
+
+type article struct {
+	qb.Tracked
+	ID        int64     `db:"id"`
+	Title     string    `db:"title"`
+	Version   int64     `db:"version,version"`
+	UpdatedAt time.Time `db:"updated_at,readonly"`
+}
+
+type stampedArticle struct {
+	ID        int64     `db:"id"`
+	Title     string    `db:"title"`
+	Version   int64     `db:"version,readonly"`
+	UpdatedAt time.Time `db:"updated_at,version"`
+}
+
+// newArticlesQB возвращает QueryBuilder с одной статьей версии 1
+func newArticlesQB(t *testing.T) qb.QueryBuilderInterface {
+	t.Helper()
+	q := newQB(t, articlesTable)
+	_, err := q.From("articles").CreateMap(map[string]any{
+		"title":      "draft",
+		"updated_at": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	return q
+}
+
+func TestOptimisticLockStructUpdate(t *testing.T) {
+	q := newArticlesQB(t)
+
+	var first, second article
+	_, err := q.From("articles").Find(1, &first)
+	require.NoError(t, err)
+	_, err = q.From("articles").Find(1, &second)
+	require.NoError(t, err)
+
+	first.Title = "first"
+	require.NoError(t, q.From("articles").Where("id = ?", 1).Update(&first))
+	require.EqualValues(t, 2, first.Version)
+
+	second.Title = "second"
+	err = q.From("articles").Where("id = ?", 1).Update(&second)
+	require.ErrorIs(t, err, qb.ErrStaleObject)
+	var stale *qb.StaleObjectError
+	require.True(t, errors.As(err, &stale))
+	require.Equal(t, "articles", stale.Table)
+	require.Equal(t, "version", stale.Column)
+	require.EqualValues(t, 1, stale.Version)
+
+	var got article
+	_, err = q.From("articles").Find(1, &got)
+	require.NoError(t, err)
+	require.Equal(t, "first", got.Title)
+	require.EqualValues(t, 2, got.Version)
+}
+
+func TestOptimisticLockSaveAndUpdateMap(t *testing.T) {
+	q := newArticlesQB(t)
+	ctx := context.Background()
+
+	var a, old article
+	_, err := q.From("articles").Find(1, &a)
+	require.NoError(t, err)
+	old = a
+
+	a.Title = "saved"
+	require.NoError(t, q.From("articles").Save(ctx, &a))
+	require.EqualValues(t, 2, a.Version)
+
+	old.Title = "lost"
+	require.ErrorIs(t, q.From("articles").Save(ctx, &old), qb.ErrStaleObject)
+
+	q.SetVersionColumn("articles", "version")
+	err = q.From("articles").Where("id = ?", 1).UpdateMap(map[string]any{"title": "map", "version": 1})
+	require.ErrorIs(t, err, qb.ErrStaleObject)
+	require.NoError(t, q.From("articles").Where("id = ?", 1).UpdateMap(map[string]any{"title": "map", "version": 2}))
+
+	// Без ожидаемой версии UpdateMap не проверяет блокировку
+	require.NoError(t, q.From("articles").Where("id = ?", 1).UpdateMap(map[string]any{"title": "free"}))
+
+	var got article
+	_, err = q.From("articles").Find(1, &got)
+	require.NoError(t, err)
+	require.Equal(t, "free", got.Title)
+	require.EqualValues(t, 3, got.Version)
+}
+
+func TestOptimisticLockTimestampColumn(t *testing.T) {
+	q := newArticlesQB(t)
+
+	var a stampedArticle
+	_, err := q.From("articles").Find(1, &a)
+	require.NoError(t, err)
+	old := a
+
+	a.Title = "fresh"
+	require.NoError(t, q.From("articles").Where("id = ?", 1).Update(&a))
+	require.True(t, a.UpdatedAt.After(old.UpdatedAt))
+
+	old.Title = "stale"
+	require.ErrorIs(t, q.From("articles").Where("id = ?", 1).Update(&old), qb.ErrStaleObject)
+}
+
+func TestOptimisticLockDoesNotMutateBuilder(t *testing.T) {
+	q := newArticlesQB(t)
+
+	var a article
+	_, err := q.From("articles").Find(1, &a)
+	require.NoError(t, err)
+
+	// Проверка версии относится к одному UPDATE и не остается в условиях билдера
+	b := q.From("articles").Where("id = ?", 1)
+	a.Title = "first"
+	require.NoError(t, b.Update(&a))
+	a.Title = "second"
+	require.NoError(t, b.Update(&a))
+	require.EqualValues(t, 3, a.Version)
+
+	count, err := b.Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+}
+
+func TestOptimisticLockTimestampPrecision(t *testing.T) {
+	q := newArticlesQB(t)
+
+	var a stampedArticle
+	_, err := q.From("articles").Find(1, &a)
+	require.NoError(t, err)
+	a.Title = "micro"
+	require.NoError(t, q.From("articles").Where("id = ?", 1).Update(&a))
+	require.True(t, a.UpdatedAt.Equal(a.UpdatedAt.Truncate(time.Microsecond)))
+
+	q.SetVersionPrecision(time.Second)
+	a.Title = "second"
+	require.NoError(t, q.From("articles").Where("id = ?", 1).Update(&a))
+	require.True(t, a.UpdatedAt.Equal(a.UpdatedAt.Truncate(time.Second)))
+
+	// Записанная в модель версия совпадает с сохраненной, следующее обновление проходит
+	a.Title = "again"
+	require.NoError(t, q.From("articles").Where("id = ?", 1).Update(&a))
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const customersTable = `CREATE TABLE customers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL DEFAULT '',
+	nick TEXT NOT NULL DEFAULT 'none',
+	code TEXT NOT NULL DEFAULT '',
+	addr_street TEXT NOT NULL DEFAULT '',
+	addr_city TEXT NOT NULL DEFAULT '',
+	created_at DATETIME,
+	deleted_at DATETIME
+)`
+
+type address struct {
+	Street string `db:"street"`
+	City   string `db:"city"`
+}
+
+type customer struct {
+	qb.SoftDelete
+	ID        int64      `db:"id"`
+	Name      string     `db:"name"`
+	Nick      string     `db:"nick,omitempty"`
+	Code      string     `db:"code,insertonly"`
+	Addr      address    `db:",prefix=addr_"`
+	CreatedAt *time.Time `db:"created_at,readonly"`
+}
+
+func TestMapperWritesAndReadsPrefixedStructs(t *testing.T) {
+	q := newQB(t, customersTable)
+	c := customer{Name: "ann", Code: "A1", Addr: address{Street: "Main", City: "Oslo"}}
+	id, err := q.From("customers").Create(&c)
+	require.NoError(t, err)
+
+	var got customer
+	found, err := q.From("customers").Find(id, &got)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "ann", got.Name)
+	require.Equal(t, "none", got.Nick)
+	require.Equal(t, "A1", got.Code)
+	require.Equal(t, address{Street: "Main", City: "Oslo"}, got.Addr)
+
+	var list []customer
+	_, err = q.From("customers").Get(&list)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "Oslo", list[0].Addr.City)
+
+	var ptrs []*customer
+	require.NoError(t, q.Raw("SELECT * FROM customers").Query(&ptrs))
+	require.Len(t, ptrs, 1)
+	require.Equal(t, "Main", ptrs[0].Addr.Street)
+
+	var row customer
+	require.NoError(t, q.Raw("SELECT * FROM customers WHERE id = ?", id).QueryRow(&row))
+	require.Equal(t, "Main", row.Addr.Street)
+
+	var missing customer
+	found, err = q.From("customers").Find(id.(int64)+1, &missing)
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestMapperUpdateOptions(t *testing.T) {
+	q := newQB(t, customersTable)
+	id, err := q.From("customers").Create(&customer{Name: "ann", Nick: "a", Code: "A1"})
+	require.NoError(t, err)
+
+	// insertonly и pk не обновляются, пустое omitempty не записывается
+	err = q.From("customers").Where("id = ?", id).
+		Update(&customer{ID: 99, Name: "bob", Code: "B2", Addr: address{City: "Rome"}})
+	require.NoError(t, err)
+
+	var got customer
+	found, err := q.From("customers").Find(id, &got)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "bob", got.Name)
+	require.Equal(t, "a", got.Nick)
+	require.Equal(t, "A1", got.Code)
+	require.Equal(t, "Rome", got.Addr.City)
+
+	// Явные поля задаются только колонками
+	err = q.From("customers").Where("id = ?", id).
+		Update(&customer{Name: "eve", Addr: address{Street: "Side"}}, "Name")
+	require.ErrorContains(t, err, "no column Name")
+	err = q.From("customers").Where("id = ?", id).
+		Update(&customer{Name: "eve", Addr: address{Street: "Side"}}, "name", "addr_street")
+	require.NoError(t, err)
+	found, err = q.From("customers").Find(id, &got)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "eve", got.Name)
+	require.Equal(t, "Side", got.Addr.Street)
+	require.Equal(t, "Rome", got.Addr.City)
+}
+
+func TestMapperRejectsUnknownColumns(t *testing.T) {
+	q := newQB(t, customersTable)
+	id, err := q.From("customers").Create(&customer{Name: "ann"})
+	require.NoError(t, err)
+
+	err = q.From("customers").Where("id = ?", id).Update(&customer{Name: "bob"}, "name", "nmae")
+	require.ErrorContains(t, err, "no column nmae")
+
+	_, err = q.From("customers").Create(&customer{Name: "eve"}, "Nmae")
+	require.ErrorContains(t, err, "no column Nmae")
+
+	var got customer
+	found, err := q.From("customers").Find(id, &got)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "ann", got.Name)
+	count, err := q.From("customers").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+}
+
+// legacyRow встраиваемая структура со своей колонкой id
+type legacyRow struct {
+	ID    int64  `db:"id"`
+	Email string `db:"email"`
+}
+
+type shadowingUser struct {
+	legacyRow
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestMapperOuterColumnShadowsEmbedded(t *testing.T) {
+	q := newQB(t, usersTable)
+	id, err := q.From("users").Create(&shadowingUser{Name: "ann", legacyRow: legacyRow{Email: "a@x"}})
+	require.NoError(t, err)
+
+	var got shadowingUser
+	found, err := q.From("users").Find(id, &got)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, id, got.ID)
+	require.Zero(t, got.legacyRow.ID)
+	require.Equal(t, "a@x", got.Email)
+
+	// Первичный ключ — внешняя колонка id, поэтому Save обновляет запись, а не вставляет новую
+	got.Name = "bob"
+	require.NoError(t, q.From("users").Save(context.Background(), &got))
+	var names []string
+	require.NoError(t, q.From("users").Pluck("name", &names))
+	require.Equal(t, []string{"bob"}, names)
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// metricsByOperation суммирует число запросов, строк и ошибок снимка по операциям
+func metricsByOperation(snapshot []qb.QueryMetrics) map[string]qb.QueryMetrics {
+	result := make(map[string]qb.QueryMetrics)
+	for _, m := range snapshot {
+		total := result[m.Operation]
+		total.Operation = m.Operation
+		total.Count += m.Count
+		total.Rows += m.Rows
+		total.ErrorCount += m.ErrorCount
+		result[m.Operation] = total
+	}
+	return result
+}
+
+func TestMetricsRecordEveryExecutionPath(t *testing.T) {
+	q := newQB(t, usersTable)
+	collector := qb.NewMetricsCollector()
+	q.SetMetrics(collector)
+
+	for _, name := range []string{"ann", "bob"} {
+		_, err := q.From("users").CreateMap(map[string]any{"name": name})
+		require.NoError(t, err)
+	}
+	var users []user
+	_, err := q.From("users").Get(&users)
+	require.NoError(t, err)
+	require.NoError(t, q.From("users").Where("name = ?", "ann").UpdateMap(map[string]any{"email": "a@x"}))
+	require.NoError(t, q.From("users").Where("name = ?", "bob").Delete())
+	require.NoError(t, q.Raw("SELECT * FROM users").Query(&users))
+	require.NoError(t, q.Transaction(func(tx *qb.Transaction) error {
+		_, err := tx.From("users").CreateMap(map[string]any{"name": "eve"})
+		return err
+	}))
+
+	ops := metricsByOperation(collector.Snapshot())
+	require.EqualValues(t, 3, ops["insert"].Count)
+	require.EqualValues(t, 2, ops["select"].Count)
+	require.EqualValues(t, 3, ops["select"].Rows)
+	require.EqualValues(t, 1, ops["update"].Count)
+	require.EqualValues(t, 1, ops["update"].Rows)
+	require.EqualValues(t, 1, ops["delete"].Count)
+	require.EqualValues(t, 1, ops["begin"].Count)
+	require.EqualValues(t, 1, ops["commit"].Count)
+
+	for _, m := range collector.Snapshot() {
+		require.Len(t, m.Buckets, len(collector.Buckets())+1)
+		require.LessOrEqual(t, m.MinTime, m.MaxTime)
+		if m.Operation != "begin" && m.Operation != "commit" {
+			require.Equal(t, "users", m.Table)
+		}
+	}
+
+	collector.Reset()
+	require.Empty(t, collector.Snapshot())
+}
+
+func TestMetricsErrorsByClass(t *testing.T) {
+	q := newQB(t, usersTable)
+	collector := qb.NewMetricsCollector()
+	q.SetMetrics(collector)
+
+	require.Error(t, q.Raw("SELEC * FROM users").Exec())
+	require.Error(t, q.Raw("INSERT INTO users (id, name) VALUES (1, 'a'), (1, 'b')").Exec())
+
+	var errs = make(map[string]int64)
+	for _, m := range collector.Snapshot() {
+		for class, n := range m.Errors {
+			errs[class] += n
+		}
+	}
+	require.Equal(t, map[string]int64{"syntax": 1, "constraint": 1}, errs)
+
+	require.Equal(t, "timeout", qb.ErrorClass(errors.New("lock wait timeout exceeded")))
+	require.Equal(t, "other", qb.ErrorClass(errors.New("boom")))
+	require.Empty(t, qb.ErrorClass(nil))
+}
+
+func TestMetricsFingerprintGroupsQueries(t *testing.T) {
+	q := newQB(t, usersTable)
+	collector := qb.NewMetricsCollector()
+	q.SetMetrics(collector)
+
+	var users []user
+	for _, ids := range [][]any{{1}, {1, 2, 3}} {
+		_, err := q.From("users").WhereIn("id", ids...).Get(&users)
+		require.NoError(t, err)
+	}
+	snapshot := collector.Snapshot()
+	require.Len(t, snapshot, 1)
+	require.EqualValues(t, 2, snapshot[0].Count)
+	require.Equal(t, "SELECT * FROM users WHERE id IN (?+)", snapshot[0].Fingerprint)
+
+	require.Equal(t, "SELECT * FROM t WHERE a = ? AND b = ? AND c IN (?+)",
+		qb.Fingerprint("SELECT *  FROM t\nWHERE a = 'x''y' AND b = $1 AND c IN (1, 2.5, 3)"))
+	require.Equal(t, "INSERT INTO t VALUES (?+)", qb.Fingerprint("INSERT INTO t VALUES (?, ?), (?, ?)"))
+}
+
+func TestBuilderMetricsCollector(t *testing.T) {
+	q := newQB(t, usersTable)
+	global := qb.NewMetricsCollector()
+	q.SetMetrics(global)
+	local := qb.NewMetricsCollector()
+
+	var users []user
+	_, err := q.From("users").WithMetrics(local).Get(&users)
+	require.NoError(t, err)
+	_, err = q.From("users").Get(&users)
+	require.NoError(t, err)
+
+	require.EqualValues(t, 2, metricsByOperation(global.Snapshot())["select"].Count)
+	require.EqualValues(t, 1, metricsByOperation(local.Snapshot())["select"].Count)
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// findUsers ищет пользователей по одному, как в цикле с N+1
+func findUsers(ctx context.Context, q qb.QueryBuilderInterface, ids ...int) error {
+	for _, id := range ids {
+		var u user
+		if _, err := q.From("users").Context(ctx).Find(id, &u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestNPlusOneWarnsOncePerScope(t *testing.T) {
+	q := newQB(t, usersTable)
+	logger, buf := newLogger(slog.LevelWarn)
+	q.SetLogger(logger)
+	var reports []qb.NPlusOneReport
+	q.SetNPlusOneDetector(qb.NPlusOneOptions{
+		Threshold: 2,
+		OnDetect:  func(r qb.NPlusOneReport) { reports = append(reports, r) },
+	})
+
+	// Без области запросы не проверяются
+	require.NoError(t, findUsers(context.Background(), q, 1, 2, 3, 4))
+	require.Empty(t, reports)
+
+	// Одинаковые аргументы не считаются повторением
+	require.NoError(t, findUsers(qb.WithQueryScope(context.Background()), q, 1, 1, 1, 2))
+	require.Empty(t, reports)
+
+	require.NoError(t, findUsers(qb.WithQueryScope(context.Background()), q, 1, 2, 3, 4))
+	require.Len(t, reports, 1)
+	require.Equal(t, "users", reports[0].Table)
+	require.Equal(t, 3, reports[0].Count)
+	require.Contains(t, reports[0].Fingerprint, "FROM users WHERE id = ?")
+	require.Contains(t, reports[0].FirstStack, "nplusone_test.go:")
+
+	var warnings int
+	for _, record := range buf.records(t) {
+		if record["msg"] == "N+1 query detected" {
+			warnings++
+			require.Equal(t, "WARN", record["level"])
+		}
+	}
+	require.Equal(t, 1, warnings)
+}
+
+func TestNPlusOneErrorMode(t *testing.T) {
+	q := newQB(t, usersTable)
+	q.SetNPlusOneDetector(qb.NPlusOneOptions{Threshold: 2, Mode: qb.NPlusOneModeError})
+
+	err := findUsers(qb.WithQueryScope(context.Background()), q, 1, 2, 3)
+	var nErr *qb.NPlusOneError
+	require.True(t, errors.As(err, &nErr))
+	require.Equal(t, 3, nErr.Report.Count)
+	require.Contains(t, err.Error(), "N+1 query detected")
+
+	// Записи не проверяются
+	ctx := qb.WithQueryScope(context.Background())
+	for i := 0; i < 4; i++ {
+		_, err := q.From("users").Context(ctx).CreateMap(map[string]any{"name": i})
+		require.NoError(t, err)
+	}
+
+	q.SetNPlusOneDetector(qb.NPlusOneOptions{})
+	require.NoError(t, findUsers(qb.WithQueryScope(context.Background()), q, 1, 2, 3))
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const outboxTable = `CREATE TABLE outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT, message_key TEXT, payload BLOB, headers BLOB,
+	attempts INTEGER, last_error TEXT, locked_until DATETIME, locked_by TEXT,
+	delivered_at DATETIME, created_at DATETIME
+)`
+
+type recordingPublisher struct {
+	published []string
+	fail      map[string]bool
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msg qb.OutboxMessage) error {
+	if p.fail[string(msg.Payload)] {
+		return errors.New("broker down")
+	}
+	p.published = append(p.published, string(msg.Payload))
+	return nil
+}
+
+func TestOutboxRelayDeliversInOrderPerKey(t *testing.T) {
+	q := newQB(t, outboxTable)
+	err := q.Transaction(func(tx *qb.Transaction) error {
+		for _, payload := range []string{`"a1"`, `"a2"`} {
+			if err := tx.OutboxWithKey("orders", "a", []byte(payload), nil); err != nil {
+				return err
+			}
+		}
+		return tx.OutboxWithKey("orders", "b", []byte(`"b1"`), map[string]string{"v": "1"})
+	})
+	require.NoError(t, err)
+
+	pub := &recordingPublisher{fail: map[string]bool{`"a1"`: true}}
+	relay := q.OutboxRelay(pub, qb.OutboxRelayOptions{Backoff: func(int) time.Duration { return 0 }})
+
+	// a1 не доставлен, поэтому a2 ждет, а b1 уходит
+	delivered, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, []string{`"b1"`}, pub.published)
+
+	pub.fail = nil
+	for i := 0; i < 2; i++ {
+		_, err = relay.RelayOnce(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{`"b1"`, `"a1"`, `"a2"`}, pub.published)
+}
+
+func TestOutboxRelayRecordsBuilderMetrics(t *testing.T) {
+	q := newQB(t, outboxTable)
+	metrics := qb.NewMetricsCollector()
+	q.SetMetrics(metrics)
+	require.NoError(t, q.Transaction(func(tx *qb.Transaction) error {
+		return tx.Outbox("user.created", map[string]int{"id": 1}, nil)
+	}))
+
+	_, err := q.OutboxRelay(&recordingPublisher{}, qb.OutboxRelayOptions{}).RelayOnce(context.Background())
+	require.NoError(t, err)
+
+	var publish []qb.QueryMetrics
+	for _, m := range metrics.Snapshot() {
+		assert.NotContains(t, m.Fingerprint, "user.created")
+		assert.NotContains(t, m.Operation, "user.created")
+		if m.Operation == "publish" {
+			publish = append(publish, m)
+		}
+	}
+	require.Len(t, publish, 1)
+	assert.Equal(t, "outbox", publish[0].Table)
+	assert.Equal(t, int64(1), publish[0].Count)
+}
+
+func TestOutboxRelaySkipsBackedOffKeys(t *testing.T) {
+	q := newQB(t, outboxTable)
+	err := q.Transaction(func(tx *qb.Transaction) error {
+		for _, key := range []string{"a", "b", "c"} {
+			if err := tx.OutboxWithKey("orders", key, []byte(`"`+key+`"`), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	pub := &recordingPublisher{fail: map[string]bool{`"a"`: true, `"b"`: true}}
+	relay := q.OutboxRelay(pub, qb.OutboxRelayOptions{
+		BatchSize: 2,
+		Backoff:   func(int) time.Duration { return time.Hour },
+	})
+
+	delivered, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+
+	// Головы a и b ждут повтора, их место в пачке занимает c
+	delivered, err = relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, []string{`"c"`}, pub.published)
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, e *qb.PrometheusExporter) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	return rec.Body.String()
+}
+
+func runUserQueries(t *testing.T, q qb.QueryBuilderInterface) {
+	t.Helper()
+	var names []string
+	for _, where := range []string{"id = ?", "name = ?", "email = ?"} {
+		_, err := q.From("users").Select("name").Where(where, "x").Get(&names)
+		require.NoError(t, err)
+	}
+}
+
+func TestPrometheusAggregatesWithoutFingerprints(t *testing.T) {
+	q := newQB(t, usersTable)
+	q.SetMetrics(qb.NewMetricsCollector())
+	runUserQueries(t, q)
+
+	out := scrape(t, q.PrometheusExporter())
+	assert.Contains(t, out, `qb_queries_total{table="users",operation="select"} 3`)
+	assert.Contains(t, out, `qb_query_duration_seconds_count{table="users",operation="select"} 3`)
+	assert.NotContains(t, out, "fingerprint=")
+}
+
+func TestPrometheusFingerprintLabelIsCapped(t *testing.T) {
+	q := newQB(t, usersTable)
+	q.SetMetrics(qb.NewMetricsCollector())
+	runUserQueries(t, q)
+
+	out := scrape(t, q.PrometheusExporter().Fingerprints(1))
+	var series []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "qb_queries_total{") {
+			series = append(series, line)
+		}
+	}
+	require.Len(t, series, 2)
+	assert.Contains(t, out, `qb_queries_total{table="users",operation="select",fingerprint="other"} 2`)
+}
+
+func TestPrometheusExportsReplicaPools(t *testing.T) {
+	q := qb.NewWithReplicas("sqlite3", openDB(t, usersTable), openDB(t, usersTable))
+
+	out := scrape(t, q.PrometheusExporter())
+	assert.Contains(t, out, `qb_db_open_connections{pool="primary"}`)
+	assert.Contains(t, out, `qb_db_open_connections{pool="replica_0"}`)
+}
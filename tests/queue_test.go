@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jobsTable = `CREATE TABLE jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	queue TEXT, operation TEXT, data BLOB, status TEXT,
+	priority INTEGER, attempts INTEGER, max_attempts INTEGER,
+	last_error TEXT, run_at DATETIME, locked_until DATETIME, locked_by TEXT,
+	created_at DATETIME, updated_at DATETIME
+)`
+
+func jobStatus(t *testing.T, q qb.QueryBuilderInterface, id any) qb.QueuedOperation {
+	t.Helper()
+	var job qb.QueuedOperation
+	found, err := q.From("jobs").Where("id = ?", id).First(&job)
+	require.NoError(t, err)
+	require.True(t, found)
+	return job
+}
+
+func TestQueueProcessByPriority(t *testing.T) {
+	q := newQB(t, jobsTable)
+	jq := q.JobQueue(qb.QueueOptions{})
+	ctx := context.Background()
+	_, err := jq.Enqueue(ctx, "low", nil, qb.EnqueueOptions{Priority: 1})
+	require.NoError(t, err)
+	_, err = jq.Enqueue(ctx, "high", nil, qb.EnqueueOptions{Priority: 9})
+	require.NoError(t, err)
+
+	var order []string
+	err = jq.Process(ctx, qb.DefaultQueueName, func(_ context.Context, job qb.QueuedOperation) error {
+		order = append(order, job.Operation)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"high", "low"}, order)
+	assert.Equal(t, qb.JobCompleted, jobStatus(t, q, 1).Status)
+}
+
+func TestQueueFailRetriesThenDeadLetters(t *testing.T) {
+	q := newQB(t, jobsTable)
+	jq := q.JobQueue(qb.QueueOptions{MaxAttempts: 2, Backoff: func(int) time.Duration { return 0 }})
+	ctx := context.Background()
+	id, err := jq.Enqueue(ctx, "flaky", nil, qb.EnqueueOptions{})
+	require.NoError(t, err)
+
+	calls := 0
+	err = jq.Process(ctx, qb.DefaultQueueName, func(context.Context, qb.QueuedOperation) error {
+		calls++
+		return errors.New("boom")
+	})
+	require.NoError(t, err)
+
+	job := jobStatus(t, q, id)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, qb.JobDead, job.Status)
+	require.NotNil(t, job.LastError)
+	assert.Equal(t, "boom", *job.LastError)
+}
+
+func TestQueueExpiredLockPastMaxAttemptsIsDeadLettered(t *testing.T) {
+	q := newQB(t, jobsTable)
+	jq := q.JobQueue(qb.QueueOptions{MaxAttempts: 1, VisibilityTimeout: time.Millisecond})
+	ctx := context.Background()
+	id, err := jq.Enqueue(ctx, "stuck", nil, qb.EnqueueOptions{})
+	require.NoError(t, err)
+
+	jobs, err := jq.Claim(ctx, qb.DefaultQueueName, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	// Воркер пропал, блокировка истекла
+	time.Sleep(10 * time.Millisecond)
+	jobs, err = jq.Claim(ctx, qb.DefaultQueueName, 1)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+	assert.Equal(t, qb.JobDead, jobStatus(t, q, id).Status)
+}
+
+func TestQueueWorkLogsLostResults(t *testing.T) {
+	db := openDB(t, jobsTable)
+	q := qb.NewX("sqlite3", db)
+	var logs bytes.Buffer
+	q.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	jq := q.JobQueue(qb.QueueOptions{PollInterval: time.Millisecond})
+	_, err := jq.Enqueue(context.Background(), "op", nil, qb.EnqueueOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = jq.Work(ctx, qb.DefaultQueueName, func(context.Context, qb.QueuedOperation) error {
+		// Таблица пропадает до сохранения результата задачи
+		db.MustExec("ALTER TABLE jobs RENAME TO jobs_old")
+		cancel()
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Contains(t, logs.String(), "queue "+qb.DefaultQueueName+" job 1 result not saved")
+	assert.NotContains(t, logs.String(), "args=")
+}
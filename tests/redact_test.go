@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"database/sql/driver"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const accountsTable = `CREATE TABLE accounts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login TEXT NOT NULL DEFAULT '',
+	password TEXT NOT NULL DEFAULT '',
+	api_token TEXT NOT NULL DEFAULT '',
+	bio TEXT NOT NULL DEFAULT ''
+)`
+
+// secret значение, которое само скрывает себя в логах
+type secret string
+
+func (s secret) Value() (driver.Value, error) {
+	return string(s), nil
+}
+
+func (s secret) Redacted() any {
+	return "***"
+}
+
+// loggedArgs возвращает аргументы записей отладочного лога запросов, содержащих fragment
+func loggedArgs(t *testing.T, buf *logBuffer, fragment string) [][]any {
+	t.Helper()
+	var result [][]any
+	for _, record := range buf.records(t) {
+		query, _ := record["query"].(string)
+		if record["level"] != "DEBUG" || !strings.Contains(query, fragment) {
+			continue
+		}
+		args, _ := record["arg"].([]any)
+		result = append(result, args)
+	}
+	return result
+}
+
+// newRedactedQB возвращает QueryBuilder с отладочным логом и правилами скрытия
+func newRedactedQB(t *testing.T, policy qb.RedactionPolicy) (qb.QueryBuilderInterface, *logBuffer) {
+	t.Helper()
+	q := newQB(t, accountsTable)
+	logger, buf := newLogger(slog.LevelDebug)
+	q.SetLogger(logger)
+	q.SetRedaction(policy)
+	return q, buf
+}
+
+func TestRedactionByColumn(t *testing.T) {
+	q, buf := newRedactedQB(t, qb.RedactionPolicy{Columns: []string{"password", "*_token"}})
+
+	_, err := q.From("accounts").CreateMap(map[string]any{"login": "ann", "password": "p1", "api_token": "t1"})
+	require.NoError(t, err)
+	require.NoError(t, q.From("accounts").Where("login = ?", "ann").UpdateMap(map[string]any{"password": "p2"}))
+	var count int
+	require.NoError(t, q.Raw("SELECT COUNT(*) FROM accounts WHERE login = ? AND api_token = ?", "ann", "t1").QueryRow(&count))
+	require.NoError(t, q.Transaction(func(tx *qb.Transaction) error {
+		return tx.From("accounts").BulkUpdate([]map[string]any{{"id": 1, "password": "p3"}}, "id")
+	}))
+
+	mask := qb.DefaultRedactionMask
+	inserts := loggedArgs(t, buf, "INSERT INTO accounts")
+	require.Len(t, inserts, 1)
+	require.ElementsMatch(t, []any{"ann", mask, mask}, inserts[0])
+	require.Equal(t, [][]any{{mask, "ann"}}, loggedArgs(t, buf, "UPDATE accounts SET password = ?"))
+	require.Equal(t, [][]any{{"ann", mask}}, loggedArgs(t, buf, "SELECT COUNT(*)"))
+	require.Equal(t, [][]any{{float64(1), mask, float64(1)}}, loggedArgs(t, buf, "CASE id"))
+
+	for _, record := range buf.records(t) {
+		for _, value := range []string{"p1", "p2", "p3", "t1"} {
+			require.NotContains(t, record["arg"], value)
+		}
+	}
+}
+
+func TestRedactionByTypeAndLength(t *testing.T) {
+	q, buf := newRedactedQB(t, qb.RedactionPolicy{MaxArgLength: 4})
+
+	_, err := q.From("accounts").CreateMap(map[string]any{"login": secret("ann"), "bio": "a long biography"})
+	require.NoError(t, err)
+
+	inserts := loggedArgs(t, buf, "INSERT INTO accounts")
+	require.Len(t, inserts, 1)
+	require.ElementsMatch(t, []any{"***", "a lo...(16 bytes)"}, inserts[0])
+}
+
+func TestRedactionSQLOnly(t *testing.T) {
+	q, buf := newRedactedQB(t, qb.RedactionPolicy{SQLOnly: true})
+
+	_, err := q.From("accounts").CreateMap(map[string]any{"login": "ann"})
+	require.NoError(t, err)
+
+	records := buf.records(t)
+	require.NotEmpty(t, records)
+	for _, record := range records {
+		require.NotContains(t, record, "arg")
+	}
+}
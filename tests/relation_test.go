@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const itemsTable = `CREATE TABLE items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id INTEGER NOT NULL DEFAULT 0,
+	name TEXT NOT NULL DEFAULT ''
+)`
+
+const profilesTable = `CREATE TABLE profiles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL DEFAULT 0,
+	bio TEXT NOT NULL DEFAULT ''
+)`
+
+const rolesTable = `CREATE TABLE roles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL DEFAULT ''
+)`
+
+const userRolesTable = `CREATE TABLE user_roles (
+	user_id INTEGER NOT NULL,
+	role_id INTEGER NOT NULL
+)`
+
+type item struct {
+	ID      int64  `db:"id"`
+	OrderID int64  `db:"order_id"`
+	Name    string `db:"name"`
+}
+
+type order struct {
+	ID     int64  `db:"id"`
+	UserID int64  `db:"user_id"`
+	Total  int64  `db:"total"`
+	Items  []item `db:"-" rel:"has_many,table=items,foreign_key=order_id"`
+	User   *user  `db:"-" rel:"belongs_to,table=users,foreign_key=user_id"`
+}
+
+type profile struct {
+	ID     int64  `db:"id"`
+	UserID int64  `db:"user_id"`
+	Bio    string `db:"bio"`
+}
+
+type role struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+type buyer struct {
+	ID      int64    `db:"id"`
+	Name    string   `db:"name"`
+	Email   string   `db:"email"`
+	Orders  []order  `db:"-" rel:"has_many,table=orders,foreign_key=user_id"`
+	Profile *profile `db:"-" rel:"has_one,table=profiles,foreign_key=user_id"`
+	Roles   []role   `db:"-" rel:"many_to_many,table=roles,pivot=user_roles,pivot_foreign_key=user_id,pivot_related_key=role_id"`
+}
+
+// newShopQB возвращает QueryBuilder с тремя пользователями: у ann два заказа, у bob один, у eve ни одного
+func newShopQB(t *testing.T) qb.QueryBuilderInterface {
+	t.Helper()
+	db := openDB(t, usersTable, ordersTable, itemsTable, profilesTable, rolesTable, userRolesTable)
+	db.MustExec(`INSERT INTO users (name) VALUES ('ann'), ('bob'), ('eve')`)
+	db.MustExec(`INSERT INTO orders (user_id, total) VALUES (1, 10), (1, 30), (2, 20)`)
+	db.MustExec(`INSERT INTO items (order_id, name) VALUES (1, 'pen'), (1, 'ink'), (2, 'pad'), (3, 'cup')`)
+	db.MustExec(`INSERT INTO profiles (user_id, bio) VALUES (2, 'bob here')`)
+	db.MustExec(`INSERT INTO roles (name) VALUES ('admin'), ('editor')`)
+	db.MustExec(`INSERT INTO user_roles (user_id, role_id) VALUES (1, 1), (1, 2), (2, 2)`)
+	return qb.NewX("sqlite3", db)
+}
+
+func TestEagerLoadBatchesPerLevel(t *testing.T) {
+	q := newShopQB(t)
+	hook := &slowSelects{}
+	q.AddHook(hook)
+
+	var buyers []buyer
+	_, err := q.From("users").OrderBy("id", "ASC").With("Orders", "Orders.Items").Get(&buyers)
+	require.NoError(t, err)
+	// Пользователи, заказы и товары — по одному запросу на уровень
+	require.EqualValues(t, 3, hook.count.Load())
+
+	require.Len(t, buyers, 3)
+	require.Len(t, buyers[0].Orders, 2)
+	require.Len(t, buyers[1].Orders, 1)
+	require.Empty(t, buyers[2].Orders)
+	require.NotNil(t, buyers[2].Orders)
+
+	var names []string
+	for _, it := range buyers[0].Orders[0].Items {
+		names = append(names, it.Name)
+	}
+	require.ElementsMatch(t, []string{"pen", "ink"}, names)
+	require.Equal(t, "cup", buyers[1].Orders[0].Items[0].Name)
+}
+
+func TestEagerLoadRelationTypes(t *testing.T) {
+	q := newShopQB(t)
+
+	var buyers []buyer
+	_, err := q.From("users").OrderBy("id", "ASC").With("Profile", "Roles").Get(&buyers)
+	require.NoError(t, err)
+	require.Nil(t, buyers[0].Profile)
+	require.Equal(t, "bob here", buyers[1].Profile.Bio)
+
+	roleNames := func(b buyer) []string {
+		var names []string
+		for _, r := range b.Roles {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+	require.ElementsMatch(t, []string{"admin", "editor"}, roleNames(buyers[0]))
+	require.Equal(t, []string{"editor"}, roleNames(buyers[1]))
+	require.Empty(t, roleNames(buyers[2]))
+
+	var o order
+	found, err := q.From("orders").With("User").Find(3, &o)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "bob", o.User.Name)
+}
+
+func TestEagerLoadConstraintsAndRegisteredRelations(t *testing.T) {
+	q := newShopQB(t)
+	q.AddRelation("users", "Orders", qb.Relation{
+		Type:       qb.HasMany,
+		Table:      "orders",
+		ForeignKey: "user_id",
+		Constraint: func(b *qb.Builder) { b.OrderBy("total", "DESC") },
+	})
+
+	var b buyer
+	found, err := q.From("users").Where("name = ?", "ann").
+		WithQuery("Orders", func(b *qb.Builder) { b.Where("total > ?", 5) }).
+		First(&b)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, b.Orders, 2)
+	require.EqualValues(t, 30, b.Orders[0].Total)
+	require.EqualValues(t, 10, b.Orders[1].Total)
+
+	found, err = q.From("users").Where("name = ?", "ann").
+		WithQuery("Orders", func(b *qb.Builder) { b.Where("total > ?", 15) }).
+		First(&b)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, b.Orders, 1)
+	require.EqualValues(t, 30, b.Orders[0].Total)
+
+	var plain []user
+	_, err = q.From("users").With("Missing").Get(&plain)
+	require.ErrorContains(t, err, "relation Missing of users is not defined")
+}
+
+func TestWhereHasFiltersByRelatedRows(t *testing.T) {
+	q := newShopQB(t)
+	require.NoError(t, q.AddModelRelations("users", buyer{}))
+
+	names := func(b qb.BuilderInterface) []string {
+		var names []string
+		require.NoError(t, b.OrderBy("id", "ASC").Pluck("name", &names))
+		return names
+	}
+	require.Equal(t, []string{"ann", "bob"}, names(q.From("users").WhereHas("Orders", nil, 1)))
+	require.Equal(t, []string{"ann"}, names(q.From("users").WhereHas("Orders", nil, 2)))
+	require.Equal(t, []string{"bob"}, names(q.From("users").WhereHas("Orders", func(b *qb.Builder) {
+		b.Where("total BETWEEN ? AND ?", 15, 25)
+	}, 1)))
+	require.Equal(t, []string{"eve"}, names(q.From("users").WhereDoesntHave("Orders", nil)))
+	require.Equal(t, []string{"bob", "eve"}, names(q.From("users").WhereDoesntHave("Roles", func(b *qb.Builder) {
+		b.Where("roles.name = ?", "admin")
+	})))
+
+	// Условия связи и внешнего запроса не путают порядок аргументов
+	require.Equal(t, []string{"ann"}, names(q.From("users").Where("name <> ?", "bob").WhereHas("Orders", func(b *qb.Builder) {
+		b.Where("total > ?", 5)
+	}, 2).Where("id < ?", 3)))
+}
+
+func TestWithCountAndSum(t *testing.T) {
+	q := newShopQB(t)
+	require.NoError(t, q.AddModelRelations("users", buyer{}))
+
+	var rows []struct {
+		Name        string `db:"name"`
+		OrdersCount int64  `db:"orders_count"`
+		OrdersTotal int64  `db:"orders_sum_total"`
+	}
+	_, err := q.From("users").Select("name").WithCount("Orders").WithSum("Orders", "total").
+		OrderBy("id", "ASC").Get(&rows)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.EqualValues(t, 2, rows[0].OrdersCount)
+	require.EqualValues(t, 40, rows[0].OrdersTotal)
+	require.EqualValues(t, 1, rows[1].OrdersCount)
+	require.EqualValues(t, 20, rows[1].OrdersTotal)
+	require.EqualValues(t, 0, rows[2].OrdersCount)
+	require.EqualValues(t, 0, rows[2].OrdersTotal)
+
+	_, err = q.From("users").WithCount("Missing").Get(&rows)
+	require.ErrorContains(t, err, "relation Missing of users is not defined")
+}
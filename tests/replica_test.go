@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// newReplicaQB возвращает QueryBuilder с основной базой и двумя репликами.
+// В каждой базе один пользователь с именем базы, по нему видно, откуда пришло чтение
+func newReplicaQB(t *testing.T, opts qb.ReplicaOptions) qb.QueryBuilderInterface {
+	t.Helper()
+	var dbs []*sqlx.DB
+	for _, name := range []string{"primary", "replica_0", "replica_1"} {
+		db := openDB(t, usersTable)
+		db.MustExec("INSERT INTO users (name) VALUES (?)", name)
+		dbs = append(dbs, db)
+	}
+	q := qb.NewWithReplicas("sqlite3", dbs[0], dbs[1:]...)
+	q.SetReplicaOptions(opts)
+	return q
+}
+
+// readSource возвращает имя базы, с которой прочитан билдер
+func readSource(t *testing.T, b qb.BuilderInterface) string {
+	t.Helper()
+	var u user
+	found, err := b.First(&u)
+	require.NoError(t, err)
+	require.True(t, found)
+	return u.Name
+}
+
+func TestReplicaRoundRobinReads(t *testing.T) {
+	q := newReplicaQB(t, qb.ReplicaOptions{})
+
+	var sources []string
+	for i := 0; i < 4; i++ {
+		sources = append(sources, readSource(t, q.From("users")))
+	}
+	require.Equal(t, []string{"replica_0", "replica_1", "replica_0", "replica_1"}, sources)
+
+	var names []string
+	require.NoError(t, q.From("users").Pluck("name", &names))
+	require.Equal(t, []string{"replica_0"}, names)
+}
+
+func TestReplicaPoliciesReadFromReplicas(t *testing.T) {
+	for _, policy := range []qb.ReplicaPolicy{qb.ReplicaRandom, qb.ReplicaLeastConnections} {
+		q := newReplicaQB(t, qb.ReplicaOptions{Policy: policy})
+		for i := 0; i < 5; i++ {
+			require.Contains(t, []string{"replica_0", "replica_1"}, readSource(t, q.From("users")))
+		}
+	}
+}
+
+func TestReplicaWritesAndTransactionsUsePrimary(t *testing.T) {
+	q := newReplicaQB(t, qb.ReplicaOptions{})
+
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+	count, err := q.From("users").UsePrimary().Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+	require.Equal(t, "primary", readSource(t, q.From("users").UsePrimary()))
+
+	require.NoError(t, q.Transaction(func(tx *qb.Transaction) error {
+		require.Equal(t, "primary", readSource(t, tx.From("users")))
+		return nil
+	}))
+
+	count, err = q.From("users").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+}
+
+func TestReplicaReadYourWrites(t *testing.T) {
+	q := newReplicaQB(t, qb.ReplicaOptions{StickyWindow: 100 * time.Millisecond})
+	ctx := qb.WithReadYourWrites(context.Background())
+
+	require.Contains(t, []string{"replica_0", "replica_1"}, readSource(t, q.From("users").Context(ctx)))
+	require.NoError(t, q.From("users").Context(ctx).Where("name = ?", "primary").UpdateMap(map[string]any{"email": "p@x"}))
+	require.Equal(t, "primary", readSource(t, q.From("users").Context(ctx)))
+	require.Contains(t, []string{"replica_0", "replica_1"}, readSource(t, q.From("users")))
+
+	time.Sleep(150 * time.Millisecond)
+	require.Contains(t, []string{"replica_0", "replica_1"}, readSource(t, q.From("users").Context(ctx)))
+}
+
+// newLaggingReplicaQB возвращает QueryBuilder, реплика которого не видит ни одной записи основной базы
+func newLaggingReplicaQB(t *testing.T, schema ...string) qb.QueryBuilderInterface {
+	t.Helper()
+	return qb.NewWithReplicas("sqlite3", openDB(t, schema...), openDB(t, schema...))
+}
+
+func TestReplicaQueueClaimReadsPrimary(t *testing.T) {
+	q := newLaggingReplicaQB(t, jobsTable)
+	jq := q.JobQueue(qb.QueueOptions{})
+	id, err := jq.Enqueue(context.Background(), "op", nil, qb.EnqueueOptions{})
+	require.NoError(t, err)
+
+	jobs, err := jq.Claim(context.Background(), qb.DefaultQueueName, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.EqualValues(t, id, jobs[0].ID)
+}
+
+func TestReplicaOutboxClaimReadsPrimary(t *testing.T) {
+	q := newLaggingReplicaQB(t, outboxTable)
+	require.NoError(t, q.Transaction(func(tx *qb.Transaction) error {
+		return tx.OutboxWithKey("orders", "a", []byte(`"a1"`), nil)
+	}))
+
+	pub := &recordingPublisher{}
+	delivered, err := q.OutboxRelay(pub, qb.OutboxRelayOptions{}).RelayOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, delivered)
+	require.Equal(t, []string{`"a1"`}, pub.published)
+}
+
+func TestReplicaSaveRefreshReadsPrimary(t *testing.T) {
+	q := newLaggingReplicaQB(t, membersTable)
+
+	m := member{Name: "bob"}
+	require.NoError(t, q.From("members").Save(context.Background(), &m))
+	require.EqualValues(t, 1, m.ID)
+	require.Equal(t, "new", m.Status)
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// newSchemaQB возвращает QueryBuilder с кешем и схемой acme: в SQLite это подключенная база
+func newSchemaQB(t *testing.T) qb.QueryBuilderInterface {
+	t.Helper()
+	db := openDB(t, usersTable)
+	// ATTACH действует на одно соединение
+	db.SetMaxOpenConns(1)
+	db.MustExec("ATTACH DATABASE ? AS acme", filepath.Join(t.TempDir(), "acme.db"))
+	db.MustExec(`CREATE TABLE acme.users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT '', email TEXT NOT NULL DEFAULT '')`)
+	q := qb.NewX("sqlite3", db)
+	q.(*qb.QueryBuilder).SetCache(qb.NewCacheMemory())
+	q.SetSchemaOptions(qb.SchemaOptions{})
+	return q
+}
+
+func TestSchemaQualifiesTables(t *testing.T) {
+	q := newSchemaQB(t)
+	_, err := q.ForSchema("acme").From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+
+	count, err := q.From("users").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 0, count)
+	count, err = q.From("users").Context(qb.WithSchema(context.Background(), "acme")).Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	_, err = q.ForSchema("acme; DROP TABLE users").From("users").Count()
+	require.ErrorIs(t, err, qb.ErrInvalidSchema)
+}
+
+func TestSchemaCacheInvalidatedByWrites(t *testing.T) {
+	q := newSchemaQB(t)
+	acme := q.ForSchema("acme")
+	ctx := qb.WithSchema(context.Background(), "acme")
+	id, err := acme.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+
+	read := func(b *qb.Builder) string {
+		var u user
+		found, err := b.Cache(time.Minute).Find(id, &u)
+		require.NoError(t, err)
+		require.True(t, found)
+		return u.Name
+	}
+
+	require.Equal(t, "ann", read(acme.From("users").(*qb.Builder)))
+	require.Equal(t, "ann", read(q.From("users").Context(ctx)))
+
+	require.NoError(t, acme.From("users").Where("id = ?", id).UpdateMap(map[string]any{"name": "bob"}))
+	require.Equal(t, "bob", read(acme.From("users").(*qb.Builder)))
+	require.Equal(t, "bob", read(q.From("users").Context(ctx)))
+
+	require.NoError(t, q.From("users").Context(ctx).Where("id = ?", id).UpdateMap(map[string]any{"name": "eve"}))
+	require.Equal(t, "eve", read(acme.From("users").(*qb.Builder)))
+
+	require.NoError(t, q.Raw("UPDATE acme.users SET name = ? WHERE id = ?", "max", id).Exec())
+	require.Equal(t, "max", read(q.From("users").Context(ctx)))
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const postsTable = `CREATE TABLE posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL DEFAULT 0,
+	title TEXT NOT NULL DEFAULT '',
+	active INTEGER NOT NULL DEFAULT 1,
+	deleted_at DATETIME,
+	deleted_by TEXT
+)`
+
+// newPostsQB возвращает QueryBuilder с постами: первый неактивен, второй удален
+func newPostsQB(t *testing.T, schema ...string) qb.QueryBuilderInterface {
+	t.Helper()
+	q := newQB(t, append([]string{usersTable, postsTable}, schema...)...)
+	db := q.GetDB()
+	_, err := db.Exec(`INSERT INTO users (name) VALUES ('ann')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO posts (user_id, title, active, deleted_at) VALUES
+		(1, 'draft', 0, NULL), (1, 'gone', 1, '2020-01-01 00:00:00'), (1, 'live', 1, NULL), (1, 'more', 1, NULL)`)
+	require.NoError(t, err)
+	return q
+}
+
+// postTitles возвращает заголовки постов билдера по порядку id
+func postTitles(t *testing.T, b qb.BuilderInterface) []string {
+	t.Helper()
+	var titles []string
+	require.NoError(t, b.OrderBy("posts.id", "ASC").Pluck("posts.title", &titles))
+	return titles
+}
+
+var activePosts qb.Scope = func(b *qb.Builder) { b.Where(b.QualifiedColumn("active")+" = ?", 1) }
+
+func TestGlobalScopes(t *testing.T) {
+	q := newPostsQB(t)
+	q.AddGlobalScope("posts", "active", activePosts)
+	q.UseSoftDeletes("posts")
+
+	require.Equal(t, []string{"live", "more"}, postTitles(t, q.From("posts").Where("id > ?", 0)))
+	require.Equal(t, []string{"draft", "live", "more"}, postTitles(t, q.From("posts").WithoutScope("active")))
+	require.Equal(t, []string{"gone", "live", "more"}, postTitles(t, q.From("posts").WithTrashed()))
+	require.Equal(t, []string{"gone"}, postTitles(t, q.From("posts").OnlyTrashed()))
+	require.Equal(t, []string{"draft", "gone", "live", "more"}, postTitles(t, q.From("posts").WithoutGlobalScopes()))
+
+	count, err := q.From("posts").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	// Скоупы применяются к запросам с соединениями с уточненными колонками
+	titles := postTitles(t, q.From("posts").Join("users", "users.id = posts.user_id").Where("users.name = ?", "ann"))
+	require.Equal(t, []string{"live", "more"}, titles)
+
+	// Запись затрагивает только видимые скоупам строки
+	require.NoError(t, q.From("posts").Where("user_id = ?", 1).UpdateMap(map[string]any{"title": "x"}))
+	require.Equal(t, []string{"draft", "gone", "x", "x"}, postTitles(t, q.From("posts").WithoutGlobalScopes()))
+}
+
+func TestLocalScopes(t *testing.T) {
+	q := newPostsQB(t)
+	titled := func(title string) qb.Scope {
+		return func(b *qb.Builder) { b.Where("title = ?", title) }
+	}
+
+	require.Equal(t, []string{"live", "more"}, postTitles(t, q.From("posts").Scope(activePosts).WhereNull("deleted_at")))
+	require.Equal(t, []string{"more"}, postTitles(t, q.From("posts").Scope(activePosts, titled("more"))))
+}
+
+func TestGlobalScopeReplacedByName(t *testing.T) {
+	q := newPostsQB(t)
+	q.AddGlobalScope("posts", "title", func(b *qb.Builder) { b.Where("title = ?", "draft") })
+	q.AddGlobalScope("posts", "title", func(b *qb.Builder) { b.Where("title = ?", "live") })
+
+	require.Equal(t, []string{"live"}, postTitles(t, q.From("posts")))
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// newShardedQB возвращает два шарда с диапазонами ключей [0, 100) и [100, 200)
+// и пользователями с id из соответствующего диапазона
+func newShardedQB(t *testing.T) *qb.ShardedQueryBuilder {
+	t.Helper()
+	sharded := qb.NewSharded(map[string]qb.QueryBuilderInterface{
+		"a": newQB(t, usersTable),
+		"b": newQB(t, usersTable),
+	}, qb.RangeShardResolver(
+		qb.ShardRange{From: 0, To: 100, Shard: "a"},
+		qb.ShardRange{From: 100, To: 200, Shard: "b"},
+	))
+	for _, row := range []struct {
+		id   int
+		name string
+	}{{1, "ann"}, {2, "eve"}, {101, "bob"}, {102, "dan"}} {
+		shard, err := sharded.Shard(row.id)
+		require.NoError(t, err)
+		_, err = shard.From("users").CreateMap(map[string]any{"id": row.id, "name": row.name})
+		require.NoError(t, err)
+	}
+	return sharded
+}
+
+func TestShardRoutesByKey(t *testing.T) {
+	sharded := newShardedQB(t)
+
+	b, err := sharded.From("users").Where("name <> ?", "").Shard(101)
+	require.NoError(t, err)
+	var users []user
+	_, err = b.Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Equal(t, "bob", users[0].Name)
+
+	_, err = sharded.Shard(500)
+	require.ErrorIs(t, err, qb.ErrUnknownShard)
+
+	hash := qb.HashShardResolver("a", "b")
+	first, err := hash("user-1")
+	require.NoError(t, err)
+	again, err := hash("user-1")
+	require.NoError(t, err)
+	require.Equal(t, first, again)
+}
+
+func TestShardScatterGatherMergesOrderAndLimit(t *testing.T) {
+	sharded := newShardedQB(t)
+
+	var users []user
+	require.NoError(t, sharded.From("users").OrderBy("name", "ASC").Limit(3).Get(&users))
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	require.Equal(t, []string{"ann", "bob", "dan"}, names)
+
+	users = nil
+	require.NoError(t, sharded.From("users").OrderBy("id", "DESC").Offset(1).Limit(2).Get(&users))
+	require.Len(t, users, 2)
+	require.EqualValues(t, 101, users[0].ID)
+	require.EqualValues(t, 2, users[1].ID)
+}
+
+func TestShardAggregates(t *testing.T) {
+	sharded := newShardedQB(t)
+
+	count, err := sharded.From("users").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 4, count)
+
+	sum, err := sharded.From("users").Sum("id")
+	require.NoError(t, err)
+	require.EqualValues(t, 206, sum)
+
+	minID, err := sharded.From("users").Min("id")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, minID)
+
+	maxID, err := sharded.From("users").Max("id")
+	require.NoError(t, err)
+	require.EqualValues(t, 102, maxID)
+
+	avg, err := sharded.From("users").Where("id > ?", 1).Avg("id")
+	require.NoError(t, err)
+	require.InDelta(t, 205.0/3, avg, 1e-9)
+}
+
+func TestShardCrossShardWrites(t *testing.T) {
+	sharded := newShardedQB(t)
+
+	err := sharded.From("users").UpdateMap(map[string]any{"email": "x"})
+	require.ErrorIs(t, err, qb.ErrCrossShardWrite)
+	require.ErrorIs(t, sharded.From("users").Delete(), qb.ErrCrossShardWrite)
+
+	require.NoError(t, sharded.From("users").AllowCrossShard().UpdateMap(map[string]any{"email": "x"}))
+	count, err := sharded.From("users").Where("email = ?", "x").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 4, count)
+}
+
+func TestShardAvgReadsSumAndCountTogether(t *testing.T) {
+	sharded := newShardedQB(t)
+	hook := &slowSelects{}
+	for _, key := range []int{1, 101} {
+		shard, err := sharded.Shard(key)
+		require.NoError(t, err)
+		shard.AddHook(hook)
+	}
+
+	avg, err := sharded.From("users").Avg("id")
+	require.NoError(t, err)
+	require.InDelta(t, 206.0/4, avg, 1e-9)
+	// Один запрос на шард
+	require.EqualValues(t, 2, hook.count.Load())
+}
+
+func TestShardEagerLoadChunksKeys(t *testing.T) {
+	a := newQB(t, usersTable, ordersTable)
+	_, err := a.GetDB().Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1500)
+		INSERT INTO users (name) SELECT 'u' || i FROM n`)
+	require.NoError(t, err)
+	_, err = a.GetDB().Exec(`INSERT INTO orders (user_id, total) SELECT id, 1 FROM users`)
+	require.NoError(t, err)
+	hook := &slowSelects{}
+	a.AddHook(hook)
+	sharded := qb.NewSharded(map[string]qb.QueryBuilderInterface{
+		"a": a,
+		"b": newQB(t, usersTable, ordersTable),
+	}, qb.HashShardResolver("a", "b"))
+
+	var buyers []buyer
+	require.NoError(t, sharded.From("users").Apply(func(b *qb.Builder) { b.With("Orders") }).Get(&buyers))
+	require.Len(t, buyers, 1500)
+	for _, b := range buyers {
+		require.Len(t, b.Orders, 1)
+		require.Equal(t, b.ID, b.Orders[0].UserID)
+	}
+	// Пользователи и заказы двумя частями ключей
+	require.EqualValues(t, 3, hook.count.Load())
+}
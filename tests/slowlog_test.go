@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+// logBuffer потокобезопасный приемник JSON логов
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records возвращает записанные записи лога
+func (b *logBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// newLogger возвращает JSON логгер уровня level, пишущий в буфер
+func newLogger(level slog.Level) (*slog.Logger, *logBuffer) {
+	buf := &logBuffer{}
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: level})), buf
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	q := newQB(t, usersTable)
+	logger, buf := newLogger(slog.LevelWarn)
+	q.SetLogger(logger)
+	q.SetSlowQueryLog(qb.SlowQueryOptions{Threshold: time.Nanosecond})
+
+	var users []user
+	_, err := q.From("users").Where("id = ?", 1).Get(&users)
+	require.NoError(t, err)
+
+	records := buf.records(t)
+	require.Len(t, records, 1)
+	require.Equal(t, "slow query", records[0]["msg"])
+	require.Equal(t, "WARN", records[0]["level"])
+	require.Equal(t, "SELECT * FROM users WHERE id = ?", records[0]["fingerprint"])
+	require.Contains(t, records[0]["caller"], "slowlog_test.go:")
+	require.NotContains(t, records[0], "plan")
+
+	q.SetSlowQueryLog(qb.SlowQueryOptions{Threshold: time.Hour})
+	_, err = q.From("users").Get(&users)
+	require.NoError(t, err)
+	require.Len(t, buf.records(t), 1)
+}
+
+func TestSlowQueryExplainRateLimited(t *testing.T) {
+	q := newQB(t, usersTable)
+	logger, buf := newLogger(slog.LevelWarn)
+	q.SetLogger(logger)
+	q.SetSlowQueryLog(qb.SlowQueryOptions{Threshold: time.Nanosecond, Explain: true})
+
+	var users []user
+	for i := 0; i < 2; i++ {
+		_, err := q.From("users").Where("id = ?", i).Get(&users)
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool { return len(buf.records(t)) == 2 }, time.Second, 10*time.Millisecond)
+	var plans int
+	for _, record := range buf.records(t) {
+		if plan, ok := record["plan"].(string); ok {
+			plans++
+			require.Contains(t, plan, "users")
+		}
+	}
+	require.Equal(t, 1, plans)
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const commentsTable = `CREATE TABLE comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL DEFAULT 0,
+	body TEXT NOT NULL DEFAULT '',
+	deleted_at DATETIME
+)`
+
+const flagsTable = `CREATE TABLE flags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slug TEXT NOT NULL DEFAULT '',
+	removed INTEGER NOT NULL DEFAULT 0
+)`
+
+type post struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	Title     string     `db:"title"`
+	Active    bool       `db:"active"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	DeletedBy *string    `db:"deleted_by"`
+}
+
+func TestSoftDeleteMarksRowsInUTC(t *testing.T) {
+	q := newPostsQB(t)
+	q.SetSoftDeleteOptions("posts", qb.SoftDeleteOptions{DeletedByColumn: "deleted_by"})
+
+	before := time.Now().UTC().Add(-time.Second)
+	ctx := qb.WithAuditMeta(context.Background(), qb.AuditMeta{UserID: "admin"})
+	require.NoError(t, q.From("posts").Context(ctx).Where("title = ?", "live").Delete())
+
+	var p post
+	found, err := q.From("posts").WithTrashed().Where("title = ?", "live").First(&p)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NotNil(t, p.DeletedAt)
+	require.True(t, p.DeletedAt.After(before))
+	require.Equal(t, time.UTC, p.DeletedAt.Location())
+	require.Equal(t, "admin", *p.DeletedBy)
+
+	found, err = q.From("posts").Where("title = ?", "live").First(&p)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, q.From("posts").Where("title = ?", "live").Restore())
+	found, err = q.From("posts").Where("title = ?", "live").First(&p)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Nil(t, p.DeletedAt)
+	require.Nil(t, p.DeletedBy)
+}
+
+func TestSoftDeleteBooleanColumn(t *testing.T) {
+	q := newQB(t, flagsTable)
+	q.SetSoftDeleteOptions("flags", qb.SoftDeleteOptions{Column: "removed", Type: qb.SoftDeleteBoolean})
+	for _, slug := range []string{"a", "b"} {
+		_, err := q.From("flags").CreateMap(map[string]any{"slug": slug})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, q.From("flags").Where("slug = ?", "a").SoftDelete())
+	var slugs []string
+	require.NoError(t, q.From("flags").Pluck("slug", &slugs))
+	require.Equal(t, []string{"b"}, slugs)
+	slugs = nil
+	require.NoError(t, q.From("flags").OnlyTrashed().Pluck("slug", &slugs))
+	require.Equal(t, []string{"a"}, slugs)
+
+	require.ErrorContains(t, q.From("flags").PurgeTrashed(time.Hour), "timestamp")
+}
+
+func TestSoftDeleteCascades(t *testing.T) {
+	q := newPostsQB(t, commentsTable)
+	q.SetSoftDeleteOptions("posts", qb.SoftDeleteOptions{
+		Cascade: []qb.SoftDeleteCascade{{Table: "comments", ForeignKey: "post_id"}},
+	})
+	q.UseSoftDeletes("comments")
+	_, err := q.GetDB().Exec(`INSERT INTO comments (post_id, body) VALUES (3, 'a'), (3, 'b'), (4, 'c')`)
+	require.NoError(t, err)
+
+	require.NoError(t, q.From("posts").Where("title = ?", "live").Delete())
+
+	var bodies []string
+	require.NoError(t, q.From("comments").Pluck("body", &bodies))
+	require.Equal(t, []string{"c"}, bodies)
+	count, err := q.From("comments").OnlyTrashed().Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+}
+
+func TestForceDeleteAndPurge(t *testing.T) {
+	q := newPostsQB(t)
+	q.UseSoftDeletes("posts")
+
+	require.NoError(t, q.From("posts").Where("title = ?", "more").Delete())
+	// Удален давно только "gone"
+	require.NoError(t, q.From("posts").PurgeTrashed(24*time.Hour))
+	count, err := q.From("posts").WithoutGlobalScopes().Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+
+	require.NoError(t, q.From("posts").Where("title = ?", "draft").ForceDelete())
+	require.NoError(t, q.From("posts").Where("title = ?", "more").ForceDelete())
+	var titles []string
+	require.NoError(t, q.From("posts").WithoutGlobalScopes().Pluck("title", &titles))
+	require.Equal(t, []string{"live"}, titles)
+}
+
+func TestRestoreDetectsUniqueConflicts(t *testing.T) {
+	q := newPostsQB(t)
+	q.SetSoftDeleteOptions("posts", qb.SoftDeleteOptions{UniqueKeys: [][]string{{"user_id", "title"}}})
+
+	_, err := q.From("posts").CreateMap(map[string]any{"user_id": 1, "title": "gone"})
+	require.NoError(t, err)
+	err = q.From("posts").Where("title = ?", "gone").Restore()
+	require.ErrorIs(t, err, qb.ErrRestoreConflict)
+
+	require.NoError(t, q.From("posts").Where("title = ?", "gone").Delete())
+	require.NoError(t, q.From("posts").Where("id = ?", 2).Restore())
+	count, err := q.From("posts").Where("title = ?", "gone").Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+}
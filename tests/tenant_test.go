@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const docsTable = `CREATE TABLE docs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant_id INTEGER NOT NULL DEFAULT 0,
+	user_id INTEGER NOT NULL DEFAULT 0,
+	title TEXT NOT NULL DEFAULT ''
+)`
+
+const ordersTable = `CREATE TABLE orders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL DEFAULT 0,
+	total INTEGER NOT NULL DEFAULT 0
+)`
+
+type doc struct {
+	ID       int64  `db:"id,pk"`
+	TenantID int64  `db:"tenant_id"`
+	UserID   int64  `db:"user_id"`
+	Title    string `db:"title"`
+}
+
+type user struct {
+	ID    int64  `db:"id"`
+	Name  string `db:"name"`
+	Email string `db:"email"`
+}
+
+// newTenantQB возвращает QueryBuilder с режимом арендаторов для таблицы docs
+// и документами арендаторов 1 и 2 у пользователей 1 и 2
+func newTenantQB(t *testing.T) qb.QueryBuilderInterface {
+	t.Helper()
+	q := newQB(t, usersTable, docsTable)
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+	_, err = q.From("users").CreateMap(map[string]any{"name": "bob"})
+	require.NoError(t, err)
+	_, err = q.From("docs").CreateMap(map[string]any{"tenant_id": 1, "user_id": 1, "title": "a"})
+	require.NoError(t, err)
+	_, err = q.From("docs").CreateMap(map[string]any{"tenant_id": 2, "user_id": 2, "title": "b"})
+	require.NoError(t, err)
+	q.(*qb.QueryBuilder).SetTenantMode(qb.TenantOptions{Tables: []string{"docs"}})
+	return q
+}
+
+func TestWhereSubQueryFromSubQuery(t *testing.T) {
+	q := newQB(t, usersTable, ordersTable)
+	_, err := q.From("users").CreateMap(map[string]any{"name": "ann"})
+	require.NoError(t, err)
+	_, err = q.From("users").CreateMap(map[string]any{"name": "bob"})
+	require.NoError(t, err)
+	_, err = q.From("orders").CreateMap(map[string]any{"user_id": 2})
+	require.NoError(t, err)
+
+	var users []user
+	_, err = q.From("users").
+		WhereSubQuery("id", "IN", q.From("orders").Select("user_id").SubQuery("x")).
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "bob", users[0].Name)
+}
+
+func TestUnion(t *testing.T) {
+	q := newQB(t, usersTable)
+	for _, name := range []string{"ann", "bob", "eve"} {
+		_, err := q.From("users").CreateMap(map[string]any{"name": name})
+		require.NoError(t, err)
+	}
+
+	var users []user
+	_, err := q.From("users").Where("name = ?", "ann").
+		Union(q.From("users").Where("name = ?", "eve")).
+		OrderBy("name", "DESC").
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Equal(t, "eve", users[0].Name)
+	require.Equal(t, "ann", users[1].Name)
+
+	var all []user
+	_, err = q.From("users").Where("name = ?", "ann").
+		UnionAll(q.From("users").Where("name = ?", "ann")).
+		Get(&all)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+}
+
+func TestTenantScopesReadsAndCreate(t *testing.T) {
+	q := newTenantQB(t)
+	ctx := qb.WithTenant(context.Background(), 1)
+
+	var docs []doc
+	_, err := q.From("docs").Context(ctx).Get(&docs)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	require.Equal(t, "a", docs[0].Title)
+
+	_, err = q.From("docs").Context(ctx).Create(&doc{TenantID: 2, Title: "c"})
+	require.NoError(t, err)
+	var created doc
+	found, err := q.From("docs").Context(qb.WithoutTenantScope(ctx)).Where("title = ?", "c").First(&created)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.EqualValues(t, 1, created.TenantID)
+
+	_, err = q.From("docs").Get(&docs)
+	require.ErrorIs(t, err, qb.ErrNoTenant)
+}
+
+func TestTenantScopesSubQueries(t *testing.T) {
+	q := newTenantQB(t)
+	ctx := qb.WithTenant(context.Background(), 1)
+
+	// Подзапрос без контекста получает арендатора внешнего запроса,
+	// даже если контекст задан после добавления условия
+	var users []user
+	_, err := q.From("users").
+		WhereExists(q.From("docs").Select("1").Where("docs.user_id = users.id")).
+		Context(ctx).
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "ann", users[0].Name)
+
+	_, err = q.From("users").Context(ctx).
+		WhereNotExists(q.From("docs").Select("1").Where("docs.user_id = users.id")).
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "bob", users[0].Name)
+
+	_, err = q.From("users").Context(ctx).
+		WhereSubQuery("id", "IN", q.From("docs").Select("user_id")).
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "ann", users[0].Name)
+
+	_, err = q.From("users").Context(ctx).
+		WhereGroup(func(b *qb.Builder) {
+			b.WhereExists(q.From("docs").Select("1").Where("docs.user_id = users.id"))
+		}).
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "ann", users[0].Name)
+}
+
+func TestTenantRequiredForSubQueries(t *testing.T) {
+	q := newTenantQB(t)
+
+	var users []user
+	_, err := q.From("users").
+		WhereExists(q.From("docs").Select("1").Where("docs.user_id = users.id")).
+		Get(&users)
+	require.ErrorIs(t, err, qb.ErrNoTenant)
+
+	_, err = q.From("docs").Select("id").SubQuery("x").Get(&users)
+	require.ErrorIs(t, err, qb.ErrNoTenant)
+
+	_, err = q.From("users").
+		WhereExists(q.From("docs").Select("1").Where("docs.user_id = users.id").WithoutTenant()).
+		Get(&users)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+}
+
+func TestTenantColumnNotUpdated(t *testing.T) {
+	q := newTenantQB(t)
+	ctx := qb.WithTenant(context.Background(), 1)
+
+	err := q.From("docs").Context(ctx).Where("title = ?", "a").Update(&doc{TenantID: 6, UserID: 1, Title: "a2"})
+	require.NoError(t, err)
+	err = q.From("docs").Context(ctx).Where("title = ?", "a2").UpdateMap(map[string]any{"tenant_id": 7, "user_id": 3})
+	require.NoError(t, err)
+	err = q.From("docs").Context(ctx).BulkUpdate([]map[string]any{{"id": 1, "tenant_id": 8, "title": "a3"}}, "id")
+	require.NoError(t, err)
+
+	var d doc
+	found, err := q.From("docs").Context(ctx).Find(1, &d)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.EqualValues(t, 1, d.TenantID)
+	require.EqualValues(t, 3, d.UserID)
+	require.Equal(t, "a3", d.Title)
+}
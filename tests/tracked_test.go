@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/antibomberman/qb"
+	"github.com/stretchr/testify/require"
+)
+
+const membersTable = `CREATE TABLE members (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL DEFAULT '',
+	email TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'new',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`
+
+type member struct {
+	qb.Tracked
+	ID        int64      `db:"id,pk,autoincrement"`
+	Name      string     `db:"name"`
+	Email     string     `db:"email"`
+	Status    string     `db:"status,omitempty"`
+	CreatedAt *time.Time `db:"created_at,omitempty"`
+}
+
+// updates возвращает SQL событий update, увиденных хуком
+func updates(hook *recordingHook) []string {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	var sqls []string
+	for _, event := range hook.after {
+		if event.Operation == "update" {
+			sqls = append(sqls, event.SQL)
+		}
+	}
+	return sqls
+}
+
+func TestSaveUpdatesOnlyChangedColumns(t *testing.T) {
+	q := newQB(t, membersTable)
+	_, err := q.From("members").CreateMap(map[string]any{"name": "ann", "email": "ann@x"})
+	require.NoError(t, err)
+
+	var m member
+	found, err := q.From("members").Find(1, &m)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	// Другой запрос меняет email после чтения модели
+	require.NoError(t, q.From("members").Where("id = ?", 1).UpdateMap(map[string]any{"email": "new@x"}))
+
+	hook := &recordingHook{}
+	q.AddHook(hook)
+	m.Name = "anna"
+	require.NoError(t, q.From("members").Save(context.Background(), &m))
+
+	sqls := updates(hook)
+	require.Len(t, sqls, 1)
+	require.Contains(t, sqls[0], "name = ?")
+	require.NotContains(t, sqls[0], "email")
+	// После сохранения модель перечитана из базы
+	require.Equal(t, "new@x", m.Email)
+
+	var got member
+	_, err = q.From("members").Find(1, &got)
+	require.NoError(t, err)
+	require.Equal(t, "anna", got.Name)
+	require.Equal(t, "new@x", got.Email)
+
+	// Без изменений запись не выполняется
+	require.NoError(t, q.From("members").Save(context.Background(), &got))
+	require.Len(t, updates(hook), 1)
+}
+
+func TestSaveInsertsNewModelAndRefreshesGeneratedColumns(t *testing.T) {
+	q := newQB(t, membersTable)
+
+	m := member{Name: "bob", Email: "bob@x"}
+	require.NoError(t, q.From("members").Save(context.Background(), &m))
+	require.EqualValues(t, 1, m.ID)
+	require.Equal(t, "new", m.Status)
+	require.NotNil(t, m.CreatedAt)
+
+	// Вставленная модель отслеживается, повторное сохранение обновляет только изменения
+	hook := &recordingHook{}
+	q.AddHook(hook)
+	m.Status = "active"
+	require.NoError(t, q.From("members").Save(context.Background(), &m))
+	sqls := updates(hook)
+	require.Len(t, sqls, 1)
+	require.Contains(t, sqls[0], "status = ?")
+	require.NotContains(t, sqls[0], "name")
+
+	require.ErrorContains(t, q.From("members").Save(context.Background(), m), "pointer to a struct")
+}
+
+func TestSaveFailsWhenRowIsGone(t *testing.T) {
+	q := newQB(t, membersTable)
+	m := member{Name: "bob"}
+	require.NoError(t, q.From("members").Save(context.Background(), &m))
+
+	// Запись удалена другим запросом, обновлять и перечитывать нечего
+	require.NoError(t, q.From("members").Where("id = ?", m.ID).Delete())
+	m.Name = "eve"
+	err := q.From("members").Save(context.Background(), &m)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	require.ErrorContains(t, err, "not found after save")
+}
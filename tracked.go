@@ -0,0 +1,152 @@
+package qb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Tracked встраивается в модель, чтобы запоминать значения, загруженные через First, Find или Get.
+// Save по этому снимку обновляет только измененные колонки:
+//
+//	type User struct {
+//		qb.Tracked
+//		ID   int64  `db:"id"`
+//		Name string `db:"name"`
+//	}
+type Tracked struct {
+	original map[string]any
+}
+
+func (t *Tracked) tracked() *Tracked {
+	return t
+}
+
+// trackedModel модель со встроенным Tracked
+type trackedModel interface {
+	tracked() *Tracked
+}
+
+// snapshot запоминает текущие значения колонок отслеживаемых моделей из dest
+func snapshot(dest any) {
+	items, _ := collectStructs(dest)
+	for _, item := range items {
+		if !item.CanAddr() {
+			continue
+		}
+		model, ok := item.Addr().Interface().(trackedModel)
+		if !ok {
+			continue
+		}
+		m := mapStruct(item.Type())
+		original := make(map[string]any, len(m.Fields))
+		for _, f := range m.Fields {
+			if fv, ok := f.value(item); ok {
+				original[f.Column] = snapshotValue(fv.Interface())
+			}
+		}
+		model.tracked().original = original
+	}
+}
+
+// snapshotValue копирует значение так, чтобы последующие изменения модели не меняли снимок
+func snapshotValue(v any) any {
+	v = derefValue(v)
+	if b, ok := v.([]byte); ok {
+		return bytes.Clone(b)
+	}
+	return v
+}
+
+// changedColumns возвращает колонки модели, изменившиеся с момента загрузки.
+// Без снимка изменившимися считаются все колонки
+func changedColumns(model any, columns []string, values []any) []string {
+	t, ok := model.(trackedModel)
+	if !ok || t.tracked().original == nil {
+		return columns
+	}
+	original := t.tracked().original
+	var changed []string
+	for i, column := range columns {
+		before, ok := original[column]
+		if !ok || !reflect.DeepEqual(before, snapshotValue(values[i])) {
+			changed = append(changed, column)
+		}
+	}
+	return changed
+}
+
+// afterFetch загружает связи из With и запоминает снимок отслеживаемых моделей
+func (qb *Builder) afterFetch(dest any) error {
+	if len(qb.with) > 0 {
+		if err := qb.loadRelations(dest); err != nil {
+			return err
+		}
+	}
+	snapshot(dest)
+	return nil
+}
+
+// Save сохраняет модель: INSERT при нулевом первичном ключе, иначе UPDATE только измененных колонок.
+// После записи модель перечитывается, чтобы получить значения, сгенерированные базой
+func (qb *Builder) Save(ctx context.Context, model any) error {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("qb: save needs a pointer to a struct, got %T", model)
+	}
+	pk, ok := mapStruct(v.Type()).pk()
+	if !ok {
+		return fmt.Errorf("qb: %T has no primary key", model)
+	}
+	pkValue, _ := pk.value(v)
+
+	if pkValue.IsZero() {
+		id, err := qb.related(qb.tableName).Context(ctx).Create(model)
+		if err != nil {
+			return err
+		}
+		if err := setValue(pkValue, id); err != nil {
+			return fmt.Errorf("qb: set %s: %w", pk.Column, err)
+		}
+	} else {
+		columns, values, err := writeColumns(model, false, nil)
+		if err != nil {
+			return err
+		}
+		changed := changedColumns(model, columns, values)
+		if len(changed) == 0 {
+			return nil
+		}
+		err = qb.related(qb.tableName).Context(ctx).
+			Where(pk.Column+" = ?", pkValue.Interface()).
+			Update(model, changed...)
+		if err != nil {
+			return err
+		}
+	}
+
+	found, err := qb.related(qb.tableName).Context(ctx).WithoutGlobalScopes().UsePrimary().
+		Where(pk.Column+" = ?", pkValue.Interface()).
+		First(model)
+	if err == nil && !found {
+		return fmt.Errorf("qb: %s %s %v not found after save: %w", qb.tableName, pk.Column, pkValue.Interface(), sql.ErrNoRows)
+	}
+	return err
+}
+
+// setValue записывает значение из базы в поле с приведением типа
+func setValue(field reflect.Value, value any) error {
+	value = derefValue(value)
+	if value == nil {
+		field.SetZero()
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if !rv.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("%T is not convertible to %s", value, field.Type())
+	}
+	field.Set(rv.Convert(field.Type()))
+	return nil
+}
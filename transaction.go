@@ -2,6 +2,8 @@ package qb
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -10,24 +12,33 @@ import (
 type Transaction struct {
 	Tx           *sqlx.Tx
 	QueryBuilder *QueryBuilder
+	ctx          context.Context
+
+	mu         sync.Mutex
+	invalidate map[string]struct{}
 }
 
 // Begin начинает новую транзакцию
 func (q *QueryBuilder) Begin() (*Transaction, error) {
-	tx, err := q.db.Beginx()
-	if err != nil {
-		return nil, err
-	}
-	return &Transaction{Tx: tx, QueryBuilder: q}, nil
+	return q.BeginContext(context.Background())
 }
 
 // BeginContext начинает новую транзакцию с контекстом
 func (q *QueryBuilder) BeginContext(ctx context.Context) (*Transaction, error) {
-	tx, err := q.db.BeginTxx(ctx, nil)
+	var tx *sqlx.Tx
+	err := q.observeTx(ctx, "BEGIN", func(ctx context.Context) (err error) {
+		tx, err = q.db.BeginTxx(ctx, nil)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &Transaction{Tx: tx, QueryBuilder: q}, nil
+	t := &Transaction{Tx: tx, QueryBuilder: q, ctx: ctx}
+	if err := q.setSearchPath(ctx, t); err != nil {
+		t.Rollback()
+		return nil, err
+	}
+	return t, nil
 }
 
 // Transaction выполняет функцию в транзакции
@@ -74,12 +85,48 @@ func (q *QueryBuilder) TransactionContext(ctx context.Context, fn func(*Transact
 	return tx.Commit()
 }
 
-// Commit фиксирует транзакцию
+// Commit фиксирует транзакцию и сбрасывает кеш измененных таблиц
 func (t *Transaction) Commit() error {
-	return t.Tx.Commit()
+	err := t.QueryBuilder.observeTx(t.context(), "COMMIT", func(context.Context) error {
+		return t.Tx.Commit()
+	})
+	if err == nil {
+		t.flushInvalidate()
+	}
+	return err
 }
 
 // Rollback откатывает транзакцию
 func (t *Transaction) Rollback() error {
-	return t.Tx.Rollback()
+	t.mu.Lock()
+	t.invalidate = nil
+	t.mu.Unlock()
+	return t.QueryBuilder.observeTx(t.context(), "ROLLBACK", func(context.Context) error {
+		return t.Tx.Rollback()
+	})
+}
+
+// context возвращает контекст, с которым была начата транзакция
+func (t *Transaction) context() context.Context {
+	if t.ctx == nil {
+		return context.TODO()
+	}
+	return t.ctx
+}
+
+// observeTx выполняет команду управления транзакцией с вызовом хуков, логов и метрик
+func (q *QueryBuilder) observeTx(ctx context.Context, command string, fn func(context.Context) error) error {
+	event := QueryEvent{
+		Operation: queryOperation(command),
+		SQL:       command,
+	}
+	ctx, err := q.beforeQuery(ctx, event)
+	start := time.Now()
+	if err == nil {
+		err = fn(ctx)
+	}
+	event.Duration = time.Since(start)
+	event.Err = err
+	q.afterQuery(ctx, "Transaction", start, event)
+	return err
 }